@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// accessRecord collects what handlers report about a request for its access
+// log line: the uploaded files and the transactions produced from them
+type accessRecord struct {
+	mu        sync.Mutex
+	filenames []string
+	rows      int
+}
+
+type accessRecordKey struct{}
+
+// recordUpload adds an uploaded file, and the transactions cleaned from
+// it, to the access record of ctx. It does nothing outside a request, as
+// when a job runs in the background.
+func recordUpload(ctx context.Context, filename string, rows int) {
+	record, ok := ctx.Value(accessRecordKey{}).(*accessRecord)
+	if !ok {
+		return
+	}
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.filenames = append(record.filenames, filename)
+	record.rows += rows
+}
+
+// accessLogWriter counts the status and body bytes of a response
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (aw *accessLogWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *accessLogWriter) Write(b []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	n, err := aw.ResponseWriter.Write(b)
+	aw.bytes += int64(n)
+	return n, err
+}
+
+func (aw *accessLogWriter) Flush() {
+	if flusher, ok := aw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// accessLog logs one line per request once it is answered, with the
+// client, the response status and size, how long it took, and the files
+// uploaded and transactions produced. Every response gets an X-Request-ID.
+// Requests whose handler aborts with a panic are logged too, marked as
+// aborted, before the panic goes on to net/http.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(w, r)
+		record := &accessRecord{}
+		aw := &accessLogWriter{ResponseWriter: w}
+		completed := false
+		defer func() {
+			status := aw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			record.mu.Lock()
+			defer record.mu.Unlock()
+			slog.Info("request",
+				"request_id", id,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"client_ip", clientIP(r),
+				"status", status,
+				"bytes", aw.bytes,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"filenames", record.filenames,
+				"rows", record.rows,
+				"aborted", !completed,
+			)
+		}()
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record)))
+		completed = true
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// batchOutcome reports how one file of a batch upload went
+type batchOutcome struct {
+	// Input is the archive folder holding the file's output
+	Input    string           `json:"input"`
+	Filename string           `json:"filename"`
+	Status   int              `json:"status"`
+	Error    string           `json:"error,omitempty"`
+	Summary  *cleaner.Summary `json:"summary,omitempty"`
+}
+
+// batchUpload cleans every file of a multi-file upload, carrying on past
+// the ones that fail. The zip archive returned holds the output of each
+// file in its own folder, input1/, input2/ and so on, and errors.json with
+// the outcome of every file.
+func batchUpload(w http.ResponseWriter, r *http.Request, outOpts outputOptions) {
+	logger := requestLogger(w, r)
+
+	opts, err := parseCleanOptions(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Logger = logger
+	opts.Password = r.FormValue("password")
+
+	var archived []outputFile
+	var outcomes []batchOutcome
+	for i, header := range formFiles(r) {
+		outcome := batchOutcome{Input: fmt.Sprintf("input%d", i+1), Filename: header.Filename, Status: http.StatusOK}
+		files, summary, err := cleanBatchFile(r.Context(), header, opts, outOpts)
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		var uploadErr *uploadError
+		switch {
+		case errors.As(err, &uploadErr):
+			outcome.Status, outcome.Error = uploadErr.Status, uploadErr.Message
+		case err != nil:
+			logger.Error("writing batch output", "filename", header.Filename, "error", err)
+			outcome.Status, outcome.Error = http.StatusInternalServerError, errorDetail("Error writing output", err)
+		default:
+			outcome.Summary = summary
+			for _, file := range files {
+				archived = append(archived, outputFile{outcome.Input + "/" + file.Name, file.Data})
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	report, err := json.MarshalIndent(outcomes, "", "  ")
+	if err != nil {
+		internalError(w, r, http.StatusInternalServerError, "Error encoding JSON", err)
+		return
+	}
+	archive, err := zipFiles(append(archived, outputFile{"errors.json", report}))
+	if err != nil {
+		internalError(w, r, http.StatusInternalServerError, "Error creating zip file", err)
+		return
+	}
+	writeZip(w, outputFilename("", ".zip"), archive)
+}
+
+// cleanBatchFile cleans one file of a batch upload into its output files
+func cleanBatchFile(ctx context.Context, header *multipart.FileHeader, opts cleaner.Options, outOpts outputOptions) ([]outputFile, *cleaner.Summary, error) {
+	uploadsTotal.Inc()
+	pending, err := openUpload(header, opts)
+	if err != nil {
+		uploadFailuresTotal.Inc()
+		return nil, nil, err
+	}
+	defer pending.Close()
+
+	upload, err := pending.process(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := outputFiles(upload, outOpts)
+	return files, &upload.Summary, err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// uncategorized is the category of transactions no rule matches
+const uncategorized = "Uncategorized"
+
+// categoryRule assigns Category to transactions whose description matches
+// Pattern
+type categoryRule struct {
+	Pattern  *regexp.Regexp
+	Category string
+}
+
+// categoryRules are loaded from cfg.CategoryRules on startup and applied in
+// order, the first match winning
+var categoryRules []categoryRule
+
+// loadCategoryRules reads the rules file at path. Each record holds a
+// regular expression and the category it assigns; lines starting with #
+// are comments. Patterns are case sensitive unless they start with (?i).
+func loadCategoryRules(path string) ([]categoryRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]categoryRule, 0, len(records))
+	for i, record := range records {
+		pattern, err := regexp.Compile(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		category := strings.TrimSpace(record[1])
+		if category == "" {
+			return nil, fmt.Errorf("rule %d: empty category", i+1)
+		}
+		rules = append(rules, categoryRule{pattern, category})
+	}
+	return rules, nil
+}
+
+// categorize returns the category of the first rule matching description
+func categorize(rules []categoryRule, description string) string {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(description) {
+			return rule.Category
+		}
+	}
+	return uncategorized
+}
+
+// categoryTotals counts and totals the credits and debits of one category
+type categoryTotals struct {
+	Category    string
+	CreditCount int
+	CreditTotal float64
+	DebitCount  int
+	DebitTotal  float64
+}
+
+// summarizeByCategory totals transactions per category, in the order the
+// categories appear in rules, with uncategorized last. Categories without
+// credits or debits are left out.
+func summarizeByCategory(transactions []cleaner.Transaction, rules []categoryRule) []categoryTotals {
+	index := make(map[string]int)
+	var totals []categoryTotals
+	add := func(category string) {
+		if _, ok := index[category]; !ok {
+			index[category] = len(totals)
+			totals = append(totals, categoryTotals{Category: category})
+		}
+	}
+	for _, rule := range rules {
+		add(rule.Category)
+	}
+	add(uncategorized)
+	for _, t := range transactions {
+		total := &totals[index[categorize(rules, t.Description)]]
+		switch t.Type {
+		case cleaner.Credit:
+			total.CreditCount++
+			total.CreditTotal += t.Amount
+		case cleaner.Debit:
+			total.DebitCount++
+			total.DebitTotal += t.Amount
+		}
+	}
+	used := totals[:0]
+	for _, total := range totals {
+		if total.CreditCount > 0 || total.DebitCount > 0 {
+			used = append(used, total)
+		}
+	}
+	return used
+}
+
+// writeCategoryCSV serializes the per category totals with a header line
+func writeCategoryCSV(totals []categoryTotals, opts outputOptions) (string, error) {
+	var categoryCSV strings.Builder
+	writer := csv.NewWriter(&categoryCSV)
+	writer.Comma = opts.Delimiter
+
+	writer.Write([]string{"category", "credit_count", "credit_total", "debit_count", "debit_total"})
+	for _, total := range totals {
+		writer.Write([]string{
+			total.Category,
+			strconv.Itoa(total.CreditCount),
+			formatAmount(total.CreditTotal, opts),
+			strconv.Itoa(total.DebitCount),
+			formatAmount(total.DebitTotal, opts),
+		})
+	}
+	writer.Flush()
+	return categoryCSV.String(), writer.Error()
+}
@@ -0,0 +1,195 @@
+package cleaner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// NumberFormat describes the separators used in amount cells
+type NumberFormat string
+
+const (
+	// NumberFormatUS writes 1,234.56: comma thousands, dot decimals
+	NumberFormatUS NumberFormat = "us"
+	// NumberFormatEU writes 1.234,56: dot thousands, comma decimals
+	NumberFormatEU NumberFormat = "eu"
+)
+
+// ParseNumberFormat returns the NumberFormat named by s
+func ParseNumberFormat(s string) (NumberFormat, error) {
+	switch format := NumberFormat(strings.ToLower(s)); format {
+	case NumberFormatUS, NumberFormatEU:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown number format %q", s)
+}
+
+// SignConvention decides which sign of amount is classified as a credit
+type SignConvention string
+
+const (
+	// SignDefault classifies negative amounts as credits and the rest as
+	// debits
+	SignDefault SignConvention = "default"
+	// SignReversed classifies negative amounts as debits and the rest as
+	// credits
+	SignReversed SignConvention = "reversed"
+)
+
+// ParseSignConvention returns the SignConvention named by s
+func ParseSignConvention(s string) (SignConvention, error) {
+	switch convention := SignConvention(strings.ToLower(s)); convention {
+	case SignDefault, SignReversed:
+		return convention, nil
+	}
+	return "", fmt.Errorf("unknown sign convention %q", s)
+}
+
+// DefaultCurrencySymbols are stripped from amount cells before parsing
+var DefaultCurrencySymbols = []string{"$", "€", "£"}
+
+// stripCurrency removes every occurrence of the given symbols from s together
+// with the whitespace around them, so "- $ 5.00" becomes "-5.00"
+func stripCurrency(s string, symbols []string) string {
+	for _, symbol := range symbols {
+		if symbol == "" {
+			continue
+		}
+		for {
+			i := strings.Index(s, symbol)
+			if i < 0 {
+				break
+			}
+			s = strings.TrimRightFunc(s[:i], unicode.IsSpace) + strings.TrimLeftFunc(s[i+len(symbol):], unicode.IsSpace)
+		}
+	}
+	return strings.TrimSpace(s)
+}
+
+// DefaultCreditIndicators and DefaultDebitIndicators are the trailing
+// markers banking exports write instead of a sign, as in "1,234.56 DR"
+var (
+	DefaultCreditIndicators = []string{"CR"}
+	DefaultDebitIndicators  = []string{"DR"}
+)
+
+// cutIndicator removes a trailing credit or debit indicator from s,
+// compared case-insensitively, and returns the type it indicates, or an
+// empty type when s has none
+func cutIndicator(s string, credit, debit []string) (string, Type) {
+	s = strings.TrimSpace(s)
+	for _, indicators := range []struct {
+		tokens []string
+		typ    Type
+	}{{credit, Credit}, {debit, Debit}} {
+		for _, token := range indicators.tokens {
+			if token == "" || len(s) <= len(token) || !strings.EqualFold(s[len(s)-len(token):], token) {
+				continue
+			}
+			if rest := strings.TrimSpace(s[:len(s)-len(token)]); rest != "" {
+				return rest, indicators.typ
+			}
+		}
+	}
+	return s, ""
+}
+
+// checkGrouping returns an error when the thousands separator group is
+// misplaced in s. It may only split the integer digits into groups of three
+// after a leading group of one to three, so "1,2,3" is rejected rather than
+// read as 123.
+func checkGrouping(s string, group byte) error {
+	if strings.IndexByte(s, group) < 0 {
+		return nil
+	}
+	start := strings.IndexAny(s, "0123456789")
+	if start < 0 {
+		return fmt.Errorf("invalid amount %q", s)
+	}
+	end := start
+	for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == group) {
+		end++
+	}
+	if strings.IndexByte(s[:start], group) >= 0 || strings.IndexByte(s[end:], group) >= 0 {
+		return fmt.Errorf("invalid amount %q: misplaced thousands separator", s)
+	}
+	groups := strings.Split(s[start:end], string(group))
+	if len(groups[0]) > 3 {
+		return fmt.Errorf("invalid amount %q: misplaced thousands separator", s)
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return fmt.Errorf("invalid amount %q: misplaced thousands separator", s)
+		}
+	}
+	return nil
+}
+
+// parseAmount parses an amount cell written in the given number format; the
+// zero value is treated as NumberFormatUS. Thousands separators must group
+// the integer digits by three. Negative values may be written with
+// a leading minus, a trailing minus or in accounting style parentheses.
+// Exponents, hexadecimal, NaN and infinite values are rejected: statements
+// never write amounts that way, and an exponent lets a single cell overflow
+// the totals. The returned amount is signed; "-0" parses as a positive zero.
+func parseAmount(s string, format NumberFormat) (float64, error) {
+	s = strings.TrimSpace(s)
+	group := byte(',')
+	if format == NumberFormatEU {
+		group = '.'
+	}
+	if err := checkGrouping(s, group); err != nil {
+		return 0, err
+	}
+	if format == NumberFormatEU {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+
+	switch {
+	case len(s) > 2 && strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")"):
+		s = "-" + strings.TrimSpace(s[1:len(s)-1])
+	case len(s) > 1 && strings.HasSuffix(s, "-") && !strings.HasPrefix(s, "-"):
+		s = "-" + strings.TrimSpace(s[:len(s)-1])
+	}
+
+	// ParseFloat also reads exponents, hex floats, underscores and words
+	// like "Inf"
+	if strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("0123456789.+-", r) }) >= 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if amount == 0 {
+		// Drop the sign of negative zero
+		amount = 0
+	}
+	return amount, nil
+}
+
+// parseAmountCell parses an amount cell with the configured currency
+// symbols, number format and indicators. A credit or debit indicator
+// overrides the sign: the amount is returned with the sign that classifies
+// it as the indicated type under Options.SignConvention.
+func (p *processor) parseAmountCell(cell string) (float64, error) {
+	if len(p.opts.CurrencySymbols) > 0 {
+		cell = stripCurrency(cell, p.opts.CurrencySymbols)
+	}
+	cell, indicated := cutIndicator(cell, p.opts.CreditIndicators, p.opts.DebitIndicators)
+	amount, err := parseAmount(cell, p.opts.NumberFormat)
+	if err != nil || indicated == "" {
+		return amount, err
+	}
+	amount = math.Abs(amount)
+	if (indicated == Credit) != (p.opts.SignConvention == SignReversed) {
+		amount = -amount
+	}
+	return amount, nil
+}
@@ -0,0 +1,229 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseAmountGrouping(t *testing.T) {
+	tests := []struct {
+		in      string
+		format  NumberFormat
+		want    float64
+		wantErr bool
+	}{
+		{in: "1,234.56", want: 1234.56},
+		{in: "1,234,567", want: 1234567},
+		{in: "123", want: 123},
+		{in: "-1,000", want: -1000},
+		{in: "(1,000.00)", want: -1000},
+		{in: "1,2,3", wantErr: true},
+		{in: "12,34", wantErr: true},
+		{in: "1234,567", wantErr: true},
+		{in: "1,234.5,6", wantErr: true},
+		{in: ",123", wantErr: true},
+		{in: "1.234,56", format: NumberFormatEU, want: 1234.56},
+		{in: "1.234.567", format: NumberFormatEU, want: 1234567},
+		{in: "1.2", format: NumberFormatEU, wantErr: true},
+		{in: "1,2", format: NumberFormatEU, want: 1.2},
+		{in: "1e3", wantErr: true},
+		{in: "1E308", wantErr: true},
+		{in: "-1e-400", wantErr: true},
+		{in: "0x10", wantErr: true},
+		{in: "NaN", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAmount(tt.in, tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAmount(%q, %q) error = %v, want error %v", tt.in, tt.format, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseAmount(%q, %q) = %v, want %v", tt.in, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestTotalOverflow(t *testing.T) {
+	huge := "1" + strings.Repeat("0", 308)
+	opts := DefaultOptions()
+	opts.SkipTop, opts.SkipBottom, opts.MapHeaders = 0, 0, true
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	csv := "Date,Description,Amount\n2024-01-02,a," + huge + "\n2024-01-03,b," + huge + "\n"
+	if _, err := CleanCSV(context.Background(), strings.NewReader(csv), opts); !errors.Is(err, ErrTotalOverflow) {
+		t.Errorf("got %v, want %v", err, ErrTotalOverflow)
+	}
+}
+
+func FuzzParseAmount(f *testing.F) {
+	for _, seed := range []string{"1,234.56", "-5", "5-", "(5)", "(1,234.00)", "1e3", "-0", " 12 ", "1,2,3", "1.234,56", "0x10", "NaN", "(-5)"} {
+		f.Add(seed, false)
+		f.Add(seed, true)
+	}
+	f.Fuzz(func(t *testing.T, s string, eu bool) {
+		format := NumberFormatUS
+		if eu {
+			format = NumberFormatEU
+		}
+		amount, err := parseAmount(s, format)
+		if err != nil {
+			return
+		}
+		if math.IsNaN(amount) || math.IsInf(amount, 0) {
+			t.Fatalf("parseAmount(%q) = %v", s, amount)
+		}
+		if amount == 0 {
+			if math.Signbit(amount) {
+				t.Fatalf("parseAmount(%q) = negative zero", s)
+			}
+			return
+		}
+		trimmed := strings.TrimSpace(s)
+		negative := strings.HasPrefix(trimmed, "-") || strings.HasPrefix(trimmed, "(") || strings.HasSuffix(trimmed, "-")
+		if (amount < 0) != negative {
+			t.Fatalf("parseAmount(%q) = %v, but the text is negative: %v", s, amount, negative)
+		}
+	})
+}
+
+// classify cleans a one-row statement with the given amount cell and returns
+// its transaction, failing when the row was skipped
+func classify(t *testing.T, amount string, configure func(*Options)) Transaction {
+	t.Helper()
+	csv := "Date,Description,Amount\n2024-01-02,x,\"" + amount + "\"\n"
+	result := cleanCSV(t, csv, func(opts *Options) {
+		opts.MapHeaders = true
+		if configure != nil {
+			configure(opts)
+		}
+	})
+	if len(result.Transactions) != 1 {
+		t.Fatalf("amount %q: %d transactions, skipped %v", amount, len(result.Transactions), result.Skipped)
+	}
+	return result.Transactions[0]
+}
+
+func TestNegativeStyles(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		typ  Type
+	}{
+		{in: "(1,234.56)", want: 1234.56, typ: Credit},
+		{in: "( 5.00 )", want: 5, typ: Credit},
+		{in: "1,234.56-", want: 1234.56, typ: Credit},
+		{in: "-1,234.56", want: 1234.56, typ: Credit},
+		{in: "1,234.56", want: 1234.56, typ: Debit},
+	}
+	for _, tt := range tests {
+		got := classify(t, tt.in, nil)
+		if got.Amount != tt.want || got.Type != tt.typ {
+			t.Errorf("%q = %v %s, want %v %s", tt.in, got.Amount, got.Type, tt.want, tt.typ)
+		}
+	}
+}
+
+func TestNumberFormats(t *testing.T) {
+	tests := []struct {
+		in     string
+		format NumberFormat
+		want   float64
+		typ    Type
+	}{
+		{in: "1,234.56", format: NumberFormatUS, want: 1234.56, typ: Debit},
+		{in: "-1,234.56", format: NumberFormatUS, want: 1234.56, typ: Credit},
+		{in: "1.234,56", format: NumberFormatEU, want: 1234.56, typ: Debit},
+		{in: "-1.234,56", format: NumberFormatEU, want: 1234.56, typ: Credit},
+		{in: "(2.000.000,10)", format: NumberFormatEU, want: 2000000.10, typ: Credit},
+		{in: "0,5", format: NumberFormatEU, want: 0.5, typ: Debit},
+	}
+	for _, tt := range tests {
+		got := classify(t, tt.in, func(opts *Options) { opts.NumberFormat = tt.format })
+		if got.Amount != tt.want || got.Type != tt.typ {
+			t.Errorf("%q in %s = %v %s, want %v %s", tt.in, tt.format, got.Amount, got.Type, tt.want, tt.typ)
+		}
+	}
+}
+
+func TestCurrencySymbols(t *testing.T) {
+	tests := []struct {
+		in      string
+		symbols []string
+		want    float64
+		typ     Type
+	}{
+		{in: "$1,234.56", want: 1234.56, typ: Debit},
+		{in: "€ 20.00", want: 20, typ: Debit},
+		{in: "£5", want: 5, typ: Debit},
+		{in: "-$1,234.56", want: 1234.56, typ: Credit},
+		{in: "- € 20.00", want: 20, typ: Credit},
+		{in: "(£5.00)", want: 5, typ: Credit},
+		{in: "5.00 £-", want: 5, typ: Credit},
+		{in: "CHF -12.50", symbols: []string{"CHF"}, want: 12.5, typ: Credit},
+	}
+	for _, tt := range tests {
+		got := classify(t, tt.in, func(opts *Options) {
+			if tt.symbols != nil {
+				opts.CurrencySymbols = tt.symbols
+			}
+		})
+		if got.Amount != tt.want || got.Type != tt.typ {
+			t.Errorf("%q = %v %s, want %v %s", tt.in, got.Amount, got.Type, tt.want, tt.typ)
+		}
+	}
+
+	result := cleanCSV(t, "Date,Description,Amount\n2024-01-02,x,$5\n", func(opts *Options) {
+		opts.MapHeaders = true
+		opts.CurrencySymbols = nil
+	})
+	if len(result.Transactions) != 0 {
+		t.Errorf("without currency symbols $5 was read as %v", result.Transactions)
+	}
+}
+
+func TestIndicators(t *testing.T) {
+	tests := []struct {
+		in       string
+		reversed bool
+		custom   bool
+		want     float64
+		typ      Type
+	}{
+		{in: "1,234.56 DR", want: 1234.56, typ: Debit},
+		{in: "500.00 CR", want: 500, typ: Credit},
+		{in: "1,000,000.00cr", want: 1000000, typ: Credit},
+		{in: "12,345.67Dr", want: 12345.67, typ: Debit},
+		{in: "-1,234.56 DR", want: 1234.56, typ: Debit},
+		{in: "$2,500.00 CR", want: 2500, typ: Credit},
+		{in: "1,234.56 DR", reversed: true, want: 1234.56, typ: Debit},
+		{in: "500.00 CR", reversed: true, want: 500, typ: Credit},
+		{in: "1,234.56 H", custom: true, want: 1234.56, typ: Credit},
+		{in: "1,234.56 S", custom: true, want: 1234.56, typ: Debit},
+	}
+	for _, tt := range tests {
+		got := classify(t, tt.in, func(opts *Options) {
+			if tt.reversed {
+				opts.SignConvention = SignReversed
+			}
+			if tt.custom {
+				opts.CreditIndicators, opts.DebitIndicators = []string{"H"}, []string{"S"}
+			}
+		})
+		if got.Amount != tt.want || got.Type != tt.typ {
+			t.Errorf("%q = %v %s, want %v %s", tt.in, got.Amount, got.Type, tt.want, tt.typ)
+		}
+	}
+
+	result := cleanCSV(t, "Date,Description,Amount\n2024-01-02,x,\"1,234.56 DR\"\n", func(opts *Options) {
+		opts.MapHeaders = true
+		opts.CreditIndicators, opts.DebitIndicators = nil, nil
+	})
+	if len(result.Transactions) != 0 {
+		t.Errorf("without indicators 1,234.56 DR was read as %v", result.Transactions)
+	}
+}
@@ -0,0 +1,1034 @@
+// Package cleaner extracts credit and debit transactions from bank statement
+// spreadsheets.
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Default number of rows trimmed from the top and bottom of every sheet
+const (
+	DefaultSkipTop    = 25
+	DefaultSkipBottom = 14
+)
+
+// Default column positions and header names of the date, description and
+// amount fields
+const (
+	DefaultDateColumn        = 0
+	DefaultDescriptionColumn = 24
+	DefaultAmountColumn      = 37
+
+	DefaultDateHeader        = "Date"
+	DefaultDescriptionHeader = "Description"
+	DefaultAmountHeader      = "Amount"
+)
+
+// Type classifies a transaction as a credit or a debit, or as zero when
+// the amount is exactly zero
+type Type string
+
+const (
+	Credit Type = "credit"
+	Debit  Type = "debit"
+	Zero   Type = "zero"
+)
+
+// Transaction is a single cleaned row. Amount is always positive; the
+// direction of the movement is carried by Type.
+type Transaction struct {
+	Date        string  `json:"date"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	Type        Type    `json:"type"`
+
+	// ParsedDate is the date column as a time, or the zero time if the
+	// cell could not be read as a date. Date then holds the raw cell.
+	ParsedDate time.Time `json:"-"`
+
+	// Balance is the running balance after this transaction, set when
+	// Options.OpeningBalance is given
+	Balance *float64 `json:"balance,omitempty"`
+
+	// Sheet is the name of the sheet the row was read from and Row its
+	// 1-based row number there, before trimming
+	Sheet string `json:"-"`
+	Row   int    `json:"-"`
+
+	// Columns holds the raw cells of Options.Columns, in that order. Cells
+	// missing from the row are empty.
+	Columns []string `json:"columns,omitempty"`
+}
+
+// SkipReason explains why a row produced no transaction
+type SkipReason string
+
+const (
+	SkipEmpty         SkipReason = "empty"
+	SkipUnparseable   SkipReason = "unparseable"
+	SkipTooFewColumns SkipReason = "too few columns"
+	SkipUndated       SkipReason = "undated"
+)
+
+// SkippedRow records a data row that was dropped. Row is the 1-based row
+// number in the source sheet.
+type SkippedRow struct {
+	Sheet     string     `json:"sheet"`
+	Row       int        `json:"row"`
+	Reason    SkipReason `json:"reason"`
+	RawAmount string     `json:"rawAmount"`
+}
+
+// Summary totals the outcome of a Clean run
+type Summary struct {
+	CreditCount  int     `json:"creditCount"`
+	DebitCount   int     `json:"debitCount"`
+	CreditTotal  float64 `json:"creditTotal"`
+	DebitTotal   float64 `json:"debitTotal"`
+	ZeroCount    int     `json:"zeroCount"`
+	SkippedCount int     `json:"skippedCount"`
+
+	// RowCount is the number of non-blank rows read below the header rows,
+	// whether they became transactions or not
+	RowCount int `json:"rowCount"`
+
+	// DuplicatesRemoved counts transactions dropped by Options.Dedup
+	DuplicatesRemoved int `json:"duplicatesRemoved"`
+
+	// FilteredCount counts transactions dropped by Options.MinAmount,
+	// Options.MaxAmount, Options.From and Options.To
+	FilteredCount int `json:"filteredCount"`
+
+	// ClosingBalance is the balance after the last transaction, set when
+	// Options.OpeningBalance is given
+	ClosingBalance *float64 `json:"closingBalance,omitempty"`
+
+	// WarningCount is the number of warnings raised, including any beyond
+	// those kept in Result.Warnings
+	WarningCount int `json:"warningCount"`
+
+	// SheetCount is the number of sheets selected for processing, and
+	// SheetsTruncated how many of them were left out by Options.MaxSheets
+	SheetCount      int `json:"sheetCount"`
+	SheetsTruncated int `json:"sheetsTruncated,omitempty"`
+
+	// RowsTruncated is set when rows were left unread because of
+	// Options.MaxRows
+	RowsTruncated bool `json:"rowsTruncated,omitempty"`
+
+	// AmountColumns records the zero-based amount column used for each sheet
+	AmountColumns map[string]int `json:"amountColumns,omitempty"`
+
+	// DataStart records the 1-based row of the first data row found in
+	// each sheet when Options.DetectStart is set
+	DataStart map[string]int `json:"dataStart,omitempty"`
+
+	// FooterRows records the trailing rows trimmed from each sheet when
+	// Options.DetectFooter is set
+	FooterRows map[string]int `json:"footerRows,omitempty"`
+
+	// SignConvention is the convention the amounts were classified by
+	SignConvention SignConvention `json:"signConvention"`
+}
+
+// TransactionCount returns the number of transactions in the result, which
+// is also the count of those handed to Options.OnTransaction
+func (s Summary) TransactionCount() int {
+	return s.CreditCount + s.DebitCount + s.ZeroCount
+}
+
+// add records t in the summary counts and totals
+func (s *Summary) add(t Transaction) {
+	switch t.Type {
+	case Credit:
+		s.CreditCount++
+		s.CreditTotal += t.Amount
+	case Zero:
+		s.ZeroCount++
+	default:
+		s.DebitCount++
+		s.DebitTotal += t.Amount
+	}
+}
+
+// SheetInfo records how a sheet was trimmed and which zero-based columns
+// were read from it
+type SheetInfo struct {
+	Name              string `json:"name"`
+	SkipTop           int    `json:"skipTop"`
+	SkipBottom        int    `json:"skipBottom"`
+	DateColumn        int    `json:"dateColumn"`
+	DescriptionColumn int    `json:"descriptionColumn"`
+	AmountColumn      int    `json:"amountColumn"`
+
+	// Columns are the positions Options.Columns resolved to, -1 for header
+	// names that were not found
+	Columns []int `json:"columns,omitempty"`
+
+	// HeaderRow is the 1-based row of the header, or 0 with NoHeader
+	HeaderRow int `json:"headerRow"`
+}
+
+// Result is the output of Clean
+type Result struct {
+	Transactions []Transaction
+	Skipped      []SkippedRow
+	Summary      Summary
+
+	// Warnings lists non-fatal problems, at most maxWarnings of them
+	Warnings []Warning
+
+	// Sheets lists the sheets that had rows left after trimming, in the
+	// order they were processed
+	Sheets []SheetInfo
+}
+
+// Options controls how sheets are trimmed and columns are located
+type Options struct {
+	SkipTop    int `json:"skipTop"`
+	SkipBottom int `json:"skipBottom"`
+
+	// DetectStart finds the header row of each sheet instead of skipping a
+	// fixed SkipTop rows, which is kept for sheets where nothing is found
+	DetectStart bool `json:"detectStart"`
+
+	// DetectFooter trims the trailing rows of each sheet that have no
+	// amount or are labelled as totals instead of a fixed SkipBottom rows
+	DetectFooter bool `json:"detectFooter"`
+
+	// MapHeaders resolves columns by matching the header row against the
+	// header names below instead of using the fixed column positions
+	MapHeaders        bool   `json:"mapHeaders"`
+	DateHeader        string `json:"dateHeader"`
+	DescriptionHeader string `json:"descriptionHeader"`
+	AmountHeader      string `json:"amountHeader"`
+
+	// AmountSentinels are amount cell values, compared case-insensitively,
+	// that mark a repeated header row. Such rows are passed over silently,
+	// as are rows whose amount cell names AmountHeader.
+	AmountSentinels []string `json:"amountSentinels"`
+
+	// Columns selects source columns copied into Transaction.Columns, by
+	// zero-based position or header name, so output can include other
+	// columns and reorder them. The amount column is still the one
+	// resolved above and decides classification.
+	Columns []string `json:"columns"`
+
+	// NoHeader treats every row left after trimming as data. Otherwise the
+	// first row is the header, or with MapHeaders the row among the first
+	// headerSearchRows that matches the most header names.
+	NoHeader bool `json:"noHeader"`
+
+	// HeaderRows is the number of lines the header spans after trimming,
+	// for templates with a second header line such as category labels
+	// above the column names. Data starts after both those lines and the
+	// header row, so a header matched by name on the second line is not
+	// skipped twice.
+	HeaderRows int `json:"headerRows"`
+
+	// MinColumns is the number of cells a row needs before it is
+	// considered. Rows always need to reach the date, description and
+	// amount columns, so values below that have no effect.
+	MinColumns int `json:"minColumns"`
+
+	// CalculateFormulas evaluates formula cells in the amount column that
+	// have no cached value. Calculation can be slow on large workbooks.
+	CalculateFormulas bool `json:"calculateFormulas"`
+
+	// RawValues reads workbook cells as their stored values rather than as
+	// displayed by their number format, so amounts come without currency or
+	// grouping and dates as serial numbers. It has no effect on CSV input.
+	RawValues bool `json:"rawValues"`
+
+	// Password decrypts password protected workbooks
+	Password string `json:"-"`
+
+	// Sheets restricts processing to the named sheets, and ExcludeSheets
+	// removes the named sheets from processing. Names must exist.
+	Sheets        []string `json:"sheets"`
+	ExcludeSheets []string `json:"excludeSheets"`
+
+	// MaxSheets, when positive, processes only the first MaxSheets of the
+	// selected sheets. The rest are counted in Summary.SheetsTruncated.
+	MaxSheets int `json:"maxSheets"`
+
+	// MaxRows, when positive, stops classification once MaxRows
+	// transactions have been read, before deduplication and filtering.
+	// Further rows set Summary.RowsTruncated and are left unread, or fail
+	// with a *RowLimitError when FailOnMaxRows is set.
+	MaxRows       int  `json:"maxRows"`
+	FailOnMaxRows bool `json:"failOnMaxRows"`
+
+	// CurrencySymbols are removed from amount cells, along with the spaces
+	// around them, before parsing
+	CurrencySymbols []string `json:"currencySymbols"`
+
+	// CreditIndicators and DebitIndicators are trailing markers, such as
+	// "CR" and "DR", that classify an unsigned amount cell. They are
+	// compared case-insensitively and may follow the number after a space.
+	CreditIndicators []string `json:"creditIndicators"`
+	DebitIndicators  []string `json:"debitIndicators"`
+
+	// NumberFormat selects the thousands and decimal separators of amounts
+	NumberFormat NumberFormat `json:"numberFormat"`
+
+	// TrimWhitespace trims the date and description cells and collapses
+	// runs of whitespace, including non-breaking spaces, to a single space
+	TrimWhitespace bool `json:"trimWhitespace"`
+
+	// DateFormat is the Go time layout recognised dates are written in
+	DateFormat string `json:"dateFormat"`
+
+	// Dedup drops transactions identical in date, description, amount and
+	// type to an earlier one
+	Dedup bool `json:"dedup"`
+
+	// MinAmount and MaxAmount, when set, drop transactions whose amount,
+	// which is always positive, falls outside the inclusive range. Running
+	// balances still include them.
+	MinAmount *float64 `json:"minAmount"`
+	MaxAmount *float64 `json:"maxAmount"`
+
+	// From and To, when set, keep only the rows dated from From up to but
+	// excluding To. Rows outside the range are dropped as they are read, so
+	// Dedup and the running balance only see the range. Rows whose date is
+	// not recognised are dropped too, or reported as skipped when
+	// ReportUndated is set.
+	From          *time.Time `json:"from"`
+	To            *time.Time `json:"to"`
+	ReportUndated bool       `json:"reportUndated"`
+
+	// SortBy orders the transactions, descending when SortDesc is set.
+	// SortNone keeps source order.
+	SortBy   SortField `json:"sortBy"`
+	SortDesc bool      `json:"sortDesc"`
+
+	// OpeningBalance enables the running balance. Transactions are first
+	// sorted by date, then each debit adds to the balance and each credit
+	// subtracts from it, or the reverse when CreditsIncreaseBalance is set.
+	// SortBy is applied afterwards.
+	OpeningBalance         *float64 `json:"openingBalance"`
+	CreditsIncreaseBalance bool     `json:"creditsIncreaseBalance"`
+
+	// SignConvention decides whether negative amounts are credits, the
+	// default, or debits. An empty value means SignDefault.
+	SignConvention SignConvention `json:"signConvention"`
+
+	// Strict fails on the first amount that cannot be parsed instead of
+	// skipping the row
+	Strict bool `json:"strict"`
+
+	// DetectAmountColumn locates only the amount column by AmountHeader,
+	// silently keeping the default position when the header is not found
+	DetectAmountColumn bool `json:"detectAmountColumn"`
+
+	// Logger receives warnings about skipped sheets and rows. slog.Default
+	// is used when nil.
+	Logger *slog.Logger `json:"-"`
+
+	// OnTransaction, when set, receives each transaction as soon as it is
+	// classified and filtered instead of it being kept in
+	// Result.Transactions, which is then empty; the summary still counts
+	// it. An error returned stops cleaning and is returned from Clean. It
+	// cannot be combined with options that see every transaction at once;
+	// see Streamable.
+	OnTransaction func(Transaction) error `json:"-"`
+
+	// Progress, when set, receives an update as each sheet starts and every
+	// thousand rows. Sends never block: updates are dropped while the
+	// channel is full. The channel is not closed when cleaning ends.
+	Progress chan<- Progress `json:"-"`
+}
+
+// DefaultOptions returns the options matching the standard statement template
+func DefaultOptions() Options {
+	return Options{
+		SkipTop:           DefaultSkipTop,
+		SkipBottom:        DefaultSkipBottom,
+		DateHeader:        DefaultDateHeader,
+		DescriptionHeader: DefaultDescriptionHeader,
+		AmountHeader:      DefaultAmountHeader,
+		AmountSentinels:   DefaultAmountSentinels,
+		DateFormat:        DefaultDateFormat,
+		TrimWhitespace:    true,
+		CurrencySymbols:   DefaultCurrencySymbols,
+		CreditIndicators:  DefaultCreditIndicators,
+		DebitIndicators:   DefaultDebitIndicators,
+		SignConvention:    SignDefault,
+	}
+}
+
+// Streamable reports whether transactions can be handed to OnTransaction as
+// they are classified. Dedup, OpeningBalance and SortBy need every
+// transaction before the first can be given out.
+func (o Options) Streamable() bool {
+	return !o.Dedup && o.OpeningBalance == nil && o.SortBy == SortNone
+}
+
+// ErrNotStreamable is returned when Options.OnTransaction is set together
+// with options that need every transaction at once
+var ErrNotStreamable = errors.New("OnTransaction cannot be combined with Dedup, OpeningBalance or SortBy")
+
+// ErrTotalOverflow is returned when the amounts add up to more than a
+// float64 can hold, which the summary could not be encoded with
+var ErrTotalOverflow = errors.New("transaction totals are too large to represent")
+
+// collapseSpace trims s and replaces each run of whitespace with one space.
+// unicode.IsSpace covers tabs and U+00A0 non-breaking spaces.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// UnresolvedHeadersError lists header names that could not be found in a
+// sheet's header row. The default column positions were used in their place.
+type UnresolvedHeadersError struct {
+	Names []string
+}
+
+func (e *UnresolvedHeadersError) Error() string {
+	return "unresolved header names: " + strings.Join(e.Names, ", ")
+}
+
+// MissingSheetsError lists sheet names given in Options that are not in the workbook
+type MissingSheetsError struct {
+	Names []string
+}
+
+func (e *MissingSheetsError) Error() string {
+	return "sheets not found: " + strings.Join(e.Names, ", ")
+}
+
+// selectSheets filters the available sheets by the include and exclude
+// lists in opts, keeping workbook order
+func selectSheets(available []string, opts Options) ([]string, error) {
+	var missing []string
+	for _, name := range slices.Concat(opts.Sheets, opts.ExcludeSheets) {
+		if !slices.Contains(available, name) && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingSheetsError{Names: missing}
+	}
+
+	var selected []string
+	for _, sheet := range available {
+		if len(opts.Sheets) > 0 && !slices.Contains(opts.Sheets, sheet) {
+			continue
+		}
+		if slices.Contains(opts.ExcludeSheets, sheet) {
+			continue
+		}
+		selected = append(selected, sheet)
+	}
+	return selected, nil
+}
+
+// FormatError is returned when the input cannot be read as a workbook or as
+// CSV, for example because it is truncated or corrupt
+type FormatError struct {
+	Err error
+}
+
+func (e *FormatError) Error() string {
+	return "unreadable file: " + e.Err.Error()
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// AmountError is returned in strict mode for an amount that cannot be parsed
+type AmountError struct {
+	Sheet string
+	Row   int
+	Value string
+	Err   error
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("sheet %s row %d: invalid amount %q: %v", e.Sheet, e.Row, e.Value, e.Err)
+}
+
+func (e *AmountError) Unwrap() error {
+	return e.Err
+}
+
+// RowLimitError is returned when an input holds more than Options.MaxRows
+// transactions and Options.FailOnMaxRows is set
+type RowLimitError struct {
+	Max int
+}
+
+func (e *RowLimitError) Error() string {
+	return fmt.Sprintf("input has more than %d transactions", e.Max)
+}
+
+// columnIndices holds the resolved positions of the output columns
+type columnIndices struct {
+	date, description, amount int
+}
+
+// maxIndex returns the highest resolved column position
+func (c columnIndices) maxIndex() int {
+	return max(c.date, c.description, c.amount)
+}
+
+// resolveColumns matches the header names in opts against header, case
+// insensitively, falling back to the default positions for names not found
+func resolveColumns(header []string, opts Options) (columnIndices, []string) {
+	columns := columnIndices{DefaultDateColumn, DefaultDescriptionColumn, DefaultAmountColumn}
+	if !opts.MapHeaders {
+		if opts.DetectAmountColumn {
+			if i := findHeader(header, opts.AmountHeader); i >= 0 {
+				columns.amount = i
+			}
+		}
+		return columns, nil
+	}
+
+	var unresolved []string
+	lookup := func(name string, index *int) {
+		if i := findHeader(header, name); i >= 0 {
+			*index = i
+			return
+		}
+		unresolved = append(unresolved, name)
+	}
+	lookup(opts.DateHeader, &columns.date)
+	lookup(opts.DescriptionHeader, &columns.description)
+	lookup(opts.AmountHeader, &columns.amount)
+	return columns, unresolved
+}
+
+// selectColumns resolves Options.Columns against header. Entries that are
+// numbers are positions; names not found in header are reported as
+// unresolved and resolve to -1.
+func (p *processor) selectColumns(header []string) []int {
+	var selected []int
+	for _, column := range p.opts.Columns {
+		if i, err := strconv.Atoi(column); err == nil && i >= 0 {
+			selected = append(selected, i)
+			continue
+		}
+		i := findHeader(header, column)
+		if i < 0 && !slices.Contains(p.unresolved, column) {
+			p.unresolved = append(p.unresolved, column)
+		}
+		selected = append(selected, i)
+	}
+	return selected
+}
+
+// DefaultAmountSentinels are the amount cell values of repeated header rows
+// in the standard template
+var DefaultAmountSentinels = []string{DefaultAmountHeader}
+
+// isSentinel reports whether an amount cell is a header label rather than
+// a value
+func (p *processor) isSentinel(cell string) bool {
+	cell = strings.TrimSpace(cell)
+	if cell == "" {
+		return false
+	}
+	return findHeader(p.opts.AmountSentinels, cell) >= 0 || strings.EqualFold(cell, p.opts.AmountHeader)
+}
+
+// findHeader returns the position of name in header, compared case
+// insensitively, or -1 if it is absent
+func findHeader(header []string, name string) int {
+	for i, cell := range header {
+		if strings.EqualFold(strings.TrimSpace(cell), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Clean opens the workbook at filePath and returns the transactions found in
+// all of its sheets, in source order, together with a summary. If header
+// mapping could not resolve every name, the result is still returned along
+// with an *UnresolvedHeadersError. Processing stops early with ctx.Err() once
+// ctx is done.
+func Clean(ctx context.Context, filePath string, opts Options) (*Result, error) {
+	f, err := excelize.OpenFile(filePath, excelize.Options{Password: opts.Password, RawCellValue: opts.RawValues})
+	if err != nil {
+		return nil, openError(err)
+	}
+	defer f.Close()
+	return cleanWorkbook(ctx, f, opts)
+}
+
+// CleanReader is like Clean but reads the workbook from r, holding it in memory
+func CleanReader(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	f, err := excelize.OpenReader(r, excelize.Options{Password: opts.Password, RawCellValue: opts.RawValues})
+	if err != nil {
+		return nil, openError(err)
+	}
+	defer f.Close()
+	return cleanWorkbook(ctx, f, opts)
+}
+
+// ErrWrongPassword is returned when a protected workbook cannot be decrypted
+// with Options.Password
+var ErrWrongPassword = errors.New("incorrect workbook password")
+
+// CleanFile is like Clean but works on an already open workbook, which lets
+// callers build or load it themselves. The selected sheets of f are
+// unmerged and trimmed in place.
+func CleanFile(ctx context.Context, f *excelize.File, opts Options) (*Result, error) {
+	return cleanWorkbook(ctx, f, opts)
+}
+
+// openError translates excelize open errors into this package's errors
+func openError(err error) error {
+	if errors.Is(err, excelize.ErrWorkbookPassword) {
+		return ErrWrongPassword
+	}
+	return &FormatError{Err: err}
+}
+
+// cleanWorkbook trims and classifies every selected sheet of f
+func cleanWorkbook(ctx context.Context, f *excelize.File, opts Options) (*Result, error) {
+	if opts.OnTransaction != nil && !opts.Streamable() {
+		return nil, ErrNotStreamable
+	}
+	sheets, err := selectSheets(f.GetSheetList(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newProcessor(ctx, opts)
+	sheets = p.limitSheets(sheets)
+	if props, err := f.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		p.date1904 = *props.Date1904
+	}
+	if opts.CalculateFormulas {
+		p.calculate = func(sheet string, rowIndex, col int) string {
+			cell, err := excelize.CoordinatesToCellName(col+1, rowIndex+1)
+			if err != nil {
+				return ""
+			}
+			if formula, err := f.GetCellFormula(sheet, cell); err != nil || formula == "" {
+				return ""
+			}
+			value, err := f.CalcCellValue(sheet, cell, excelize.Options{RawCellValue: opts.RawValues})
+			if err != nil {
+				p.warn(sheet, "formula in %s could not be calculated: %v", cell, err)
+				return ""
+			}
+			return value
+		}
+	}
+	p.sheetCount = len(sheets)
+	for i, sheet := range sheets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.sheetIndex = i + 1
+
+		// Remove merged cells
+		mergedCells, err := f.GetMergeCells(sheet)
+		if err != nil {
+			return nil, err
+		}
+		for _, mc := range mergedCells {
+			err = f.UnmergeCell(sheet, mc.GetStartAxis(), mc.GetEndAxis())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			return nil, &FormatError{Err: err}
+		}
+
+		p.startSheet(sheet, rows, opts)
+
+		// Skip sheets too short to survive trimming
+		if !p.canTrim(sheet, rows) {
+			continue
+		}
+
+		// Remove the last SkipBottom rows, then the first SkipTop rows.
+		// RemoveRow shifts the rows below it up, so each range is removed
+		// from its highest row number downwards.
+		for i := len(rows); i > len(rows)-p.opts.SkipBottom; i-- {
+			err := f.RemoveRow(sheet, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i := p.opts.SkipTop; i >= 1; i-- {
+			err := f.RemoveRow(sheet, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Re-read rows after removals
+		rows, err = f.GetRows(sheet)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.processRows(sheet, rows); err != nil {
+			return nil, err
+		}
+		if p.result.Summary.RowsTruncated {
+			break
+		}
+	}
+	return p.finish()
+}
+
+// cancelCheckInterval is how many rows are processed between context checks
+const cancelCheckInterval = 1000
+
+// processor accumulates the result of cleaning one or more sheets
+type processor struct {
+	ctx        context.Context
+	opts       Options
+	result     *Result
+	unresolved []string
+
+	// date1904 is set for workbooks using the 1904 date system
+	date1904 bool
+
+	// calculate, when set, evaluates the formula at the zero-based row and
+	// column of the trimmed sheet, returning "" if there is none
+	calculate func(sheet string, rowIndex, col int) string
+
+	// sheetIndex is the 1-based position of the sheet being processed
+	// among the sheetCount selected sheets
+	sheetIndex, sheetCount int
+
+	// classified counts the transactions read so far, for Options.MaxRows
+	classified int
+
+	// columns are those of the sheet being started, resolved by startSheet
+	// for detecting where its data starts and ends
+	columns columnIndices
+}
+
+func newProcessor(ctx context.Context, opts Options) *processor {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.DateFormat == "" {
+		opts.DateFormat = DefaultDateFormat
+	}
+	return &processor{ctx: ctx, opts: opts, result: &Result{}}
+}
+
+// limitSheets records the number of selected sheets in the summary and
+// drops those beyond Options.MaxSheets
+func (p *processor) limitSheets(sheets []string) []string {
+	p.result.Summary.SheetCount = len(sheets)
+	if p.opts.MaxSheets > 0 && len(sheets) > p.opts.MaxSheets {
+		p.warn("", "workbook has %d sheets; only the first %d were processed", len(sheets), p.opts.MaxSheets)
+		p.result.Summary.SheetsTruncated = len(sheets) - p.opts.MaxSheets
+		return sheets[:p.opts.MaxSheets]
+	}
+	p.opts.Logger.Info("processing sheets", "sheets", len(sheets))
+	return sheets
+}
+
+// report sends a progress update if the caller asked for them
+func (p *processor) report(sheet string, row int) {
+	if p.opts.Progress == nil {
+		return
+	}
+	select {
+	case p.opts.Progress <- Progress{Sheet: sheet, SheetIndex: p.sheetIndex, SheetCount: p.sheetCount, Row: row}:
+	default:
+	}
+}
+
+// canTrim reports whether the sheet has enough rows for the configured trim
+func (p *processor) canTrim(sheet string, rows [][]string) bool {
+	trim := p.opts.SkipTop + p.opts.SkipBottom
+	if trim > len(rows) {
+		p.warn(sheet, "sheet has %d rows, fewer than the %d trimmed, and was skipped", len(rows), trim)
+		return false
+	}
+	return true
+}
+
+// headerSearchRows is how many leading rows are searched for the header
+// when mapping headers
+const headerSearchRows = 10
+
+// headerRow returns the zero-based index of the header among the trimmed
+// rows, or -1 when there is none. Without MapHeaders the header is the first
+// row unless its amount cell holds an amount, which makes it data. With
+// MapHeaders it is the first of the leading headerSearchRows matching the
+// most header names, and there is none when no row matches any. It is
+// always -1 with NoHeader.
+func (p *processor) headerRow(rows [][]string) int {
+	if p.opts.NoHeader || len(rows) == 0 {
+		return -1
+	}
+	if !p.opts.MapHeaders {
+		columns, _ := resolveColumns(rows[0], p.opts)
+		if columns.amount < len(rows[0]) && p.isAmount(rows[0][columns.amount]) {
+			return -1
+		}
+		return 0
+	}
+	best, bestMatches := -1, 0
+	for i, row := range rows[:min(len(rows), headerSearchRows)] {
+		matches := 0
+		for _, name := range []string{p.opts.DateHeader, p.opts.DescriptionHeader, p.opts.AmountHeader} {
+			if findHeader(row, name) >= 0 {
+				matches++
+			}
+		}
+		if matches > bestMatches {
+			best, bestMatches = i, matches
+		}
+	}
+	return best
+}
+
+// processRows classifies the already trimmed rows of a sheet, which start
+// with the header unless Options.NoHeader is set. An error is returned in
+// strict mode or when the context is done.
+func (p *processor) processRows(sheet string, rows [][]string) error {
+	if len(rows) == 0 {
+		p.warn(sheet, "no rows left after trimming")
+		return nil
+	}
+
+	headerIndex := p.headerRow(rows)
+	var header []string
+	if headerIndex >= 0 {
+		header = rows[headerIndex]
+	}
+	columns, missing := resolveColumns(header, p.opts)
+	for _, name := range missing {
+		if !slices.Contains(p.unresolved, name) {
+			p.unresolved = append(p.unresolved, name)
+		}
+	}
+	if p.result.Summary.AmountColumns == nil {
+		p.result.Summary.AmountColumns = make(map[string]int)
+	}
+	p.result.Summary.AmountColumns[sheet] = columns.amount
+	p.result.Sheets = append(p.result.Sheets, SheetInfo{
+		Name:              sheet,
+		SkipTop:           p.opts.SkipTop,
+		SkipBottom:        p.opts.SkipBottom,
+		DateColumn:        columns.date,
+		DescriptionColumn: columns.description,
+		AmountColumn:      columns.amount,
+		Columns:           p.selectColumns(header),
+	})
+	if headerIndex >= 0 {
+		p.result.Sheets[len(p.result.Sheets)-1].HeaderRow = headerIndex + p.opts.SkipTop + 1
+	}
+
+	required := max(p.opts.MinColumns, columns.maxIndex()+1)
+	dataStart := 0
+	if headerIndex >= 0 {
+		dataStart = max(headerIndex+1, p.opts.HeaderRows)
+	}
+
+	for rowIndex, row := range rows {
+		if rowIndex%cancelCheckInterval == 0 {
+			if err := p.ctx.Err(); err != nil {
+				return err
+			}
+			p.report(sheet, rowIndex)
+		}
+
+		// Skip the header, any rows above it, and blank rows
+		if rowIndex < dataStart || len(row) == 0 {
+			continue
+		}
+		p.result.Summary.RowCount++
+		skip := func(reason SkipReason, rawAmount string) {
+			p.result.Skipped = append(p.result.Skipped, SkippedRow{
+				Sheet:     sheet,
+				Row:       rowIndex + p.opts.SkipTop + 1,
+				Reason:    reason,
+				RawAmount: rawAmount,
+			})
+		}
+
+		// Skip rows without sufficient columns
+		if len(row) < required {
+			rawAmount := ""
+			if columns.amount < len(row) {
+				rawAmount = row[columns.amount]
+			}
+			skip(SkipTooFewColumns, rawAmount)
+			continue
+		}
+
+		rawAmount := row[columns.amount]
+		if rawAmount == "" && p.calculate != nil {
+			// Recover formulas saved without a cached result
+			rawAmount = p.calculate(sheet, rowIndex, columns.amount)
+		}
+
+		// Handle empty or invalid amount strings
+		if p.isSentinel(rawAmount) {
+			continue
+		}
+		if rawAmount == "" {
+			skip(SkipEmpty, rawAmount)
+			continue
+		}
+
+		amount, err := p.parseAmountCell(rawAmount)
+		if err != nil && p.opts.Strict {
+			return &AmountError{Sheet: sheet, Row: rowIndex + p.opts.SkipTop + 1, Value: rawAmount, Err: err}
+		}
+		if err != nil {
+			p.opts.Logger.Warn("invalid amount", "sheet", sheet, "row", rowIndex+p.opts.SkipTop+1, "value", rawAmount, "error", err)
+			p.result.Summary.SkippedCount++
+			skip(SkipUnparseable, rawAmount)
+			continue
+		}
+
+		if p.opts.MaxRows > 0 && p.classified >= p.opts.MaxRows {
+			if p.opts.FailOnMaxRows {
+				return &RowLimitError{Max: p.opts.MaxRows}
+			}
+			p.result.Summary.RowsTruncated = true
+			p.warn(sheet, "stopped at row %d: the output is limited to %d transactions", rowIndex+p.opts.SkipTop+1, p.opts.MaxRows)
+			return nil
+		}
+
+		transaction := Transaction{
+			Date:        row[columns.date],
+			Description: row[columns.description],
+			Amount:      amount,
+			Type:        Debit,
+			Sheet:       sheet,
+			Row:         rowIndex + p.opts.SkipTop + 1,
+		}
+		if selected := p.result.Sheets[len(p.result.Sheets)-1].Columns; len(selected) > 0 {
+			transaction.Columns = make([]string, len(selected))
+			for i, col := range selected {
+				if col >= 0 && col < len(row) {
+					transaction.Columns[i] = row[col]
+				}
+			}
+		}
+		if p.opts.TrimWhitespace {
+			transaction.Date = collapseSpace(transaction.Date)
+			transaction.Description = collapseSpace(transaction.Description)
+		}
+		if t, ok := parseDate(transaction.Date, p.date1904); ok {
+			transaction.ParsedDate = t
+			transaction.Date = t.Format(p.opts.DateFormat)
+		}
+		if p.opts.From != nil || p.opts.To != nil {
+			if transaction.ParsedDate.IsZero() && p.opts.ReportUndated {
+				p.result.Summary.SkippedCount++
+				skip(SkipUndated, rawAmount)
+				continue
+			}
+			if !p.inDateRange(transaction.ParsedDate) {
+				p.result.Summary.FilteredCount++
+				continue
+			}
+		}
+
+		// Classify by the sign of the parsed value, not the text, so "-0" and
+		// amounts with leading space or a plus sign land where they belong.
+		// Negative amounts are credits, or debits under SignReversed.
+		negative := amount < 0
+		if negative {
+			// Convert the amount to positive
+			transaction.Amount = -amount
+		}
+		switch {
+		case amount == 0:
+			// Adjustment and placeholder rows are kept apart for auditing
+			transaction.Amount = 0
+			transaction.Type = Zero
+		case negative != (p.opts.SignConvention == SignReversed):
+			transaction.Type = Credit
+		}
+		if err := p.emit(transaction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit keeps a classified transaction in the result, or with
+// Options.OnTransaction filters it by amount and hands it over
+func (p *processor) emit(t Transaction) error {
+	p.classified++
+	if p.opts.OnTransaction == nil {
+		p.result.Transactions = append(p.result.Transactions, t)
+		return nil
+	}
+	if !inAmountRange(t, p.opts.MinAmount, p.opts.MaxAmount) {
+		p.result.Summary.FilteredCount++
+		return nil
+	}
+	p.result.Summary.add(t)
+	return p.opts.OnTransaction(t)
+}
+
+// inDateRange reports whether t falls within Options.From and Options.To.
+// The zero time, an unrecognised date, never does.
+func (p *processor) inDateRange(t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	return (p.opts.From == nil || !t.Before(*p.opts.From)) && (p.opts.To == nil || t.Before(*p.opts.To))
+}
+
+// finish applies the post-classification options and totals the summary. The
+// result is returned with an *UnresolvedHeadersError if any header names
+// could not be found.
+func (p *processor) finish() (*Result, error) {
+	if p.opts.Dedup {
+		p.result.Transactions, p.result.Summary.DuplicatesRemoved = dedupe(p.result.Transactions)
+	}
+	if p.opts.OpeningBalance != nil {
+		sortTransactions(p.result.Transactions, SortDate, false)
+		closing := runningBalance(p.result.Transactions, *p.opts.OpeningBalance, p.opts.CreditsIncreaseBalance)
+		p.result.Summary.ClosingBalance = &closing
+	}
+	if p.opts.MinAmount != nil || p.opts.MaxAmount != nil {
+		var filtered int
+		p.result.Transactions, filtered = filterAmounts(p.result.Transactions, p.opts.MinAmount, p.opts.MaxAmount)
+		p.result.Summary.FilteredCount += filtered
+	}
+	sortTransactions(p.result.Transactions, p.opts.SortBy, p.opts.SortDesc)
+	p.result.Summary.SignConvention = SignDefault
+	if p.opts.SignConvention == SignReversed {
+		p.result.Summary.SignConvention = SignReversed
+	}
+	for _, t := range p.result.Transactions {
+		p.result.Summary.add(t)
+	}
+	if s := p.result.Summary; math.IsInf(s.CreditTotal, 0) || math.IsInf(s.DebitTotal, 0) ||
+		(s.ClosingBalance != nil && math.IsInf(*s.ClosingBalance, 0)) {
+		return nil, ErrTotalOverflow
+	}
+	p.warnTotals()
+
+	if len(p.unresolved) > 0 {
+		return p.result, &UnresolvedHeadersError{Names: p.unresolved}
+	}
+	return p.result, nil
+}
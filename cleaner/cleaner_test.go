@@ -0,0 +1,420 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin/internal/testworkbook"
+	"github.com/xuri/excelize/v2"
+)
+
+// templateRow returns a CSV row of the standard template, with the date,
+// description and amount in their default columns
+func templateRow(date, description, amount string) string {
+	cells := make([]string, DefaultAmountColumn+1)
+	cells[DefaultDateColumn] = date
+	cells[DefaultDescriptionColumn] = description
+	cells[DefaultAmountColumn] = amount
+	return strings.Join(cells, ",") + "\n"
+}
+
+// cleanRows cleans a workbook holding rows with the default options changed
+// by configure
+func cleanRows(t *testing.T, rows [][]string, configure func(*Options)) *Result {
+	t.Helper()
+	f, err := testworkbook.New(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return cleanWorkbookFile(t, f, configure)
+}
+
+// cleanWorkbookFile cleans f with the default options changed by configure.
+// Unresolved header names are not an error, as the result is still returned.
+func cleanWorkbookFile(t *testing.T, f *excelize.File, configure func(*Options)) *Result {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if configure != nil {
+		configure(&opts)
+	}
+	result, err := CleanFile(context.Background(), f, opts)
+	var unresolved *UnresolvedHeadersError
+	if err != nil && !errors.As(err, &unresolved) {
+		t.Fatalf("CleanFile: %v", err)
+	}
+	return result
+}
+
+// numberedRows returns n template rows described "row 1" to "row n"
+func numberedRows(n int) [][]string {
+	rows := make([][]string, n)
+	for i := range rows {
+		rows[i] = make([]string, DefaultAmountColumn+1)
+		rows[i][DefaultDateColumn] = "2024-01-02"
+		rows[i][DefaultDescriptionColumn] = fmt.Sprintf("row %d", i+1)
+		rows[i][DefaultAmountColumn] = "-1"
+	}
+	return rows
+}
+
+func TestCanTrim(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       int
+		skipTop    int
+		skipBottom int
+		want       int
+		warnings   int
+	}{
+		{name: "ten rows under the default trim", rows: 10, skipTop: DefaultSkipTop, skipBottom: DefaultSkipBottom, warnings: 1},
+		{name: "ten rows trimmed to nothing", rows: 10, skipTop: 6, skipBottom: 4, warnings: 1},
+		{name: "ten rows with data left", rows: 10, skipTop: 3, skipBottom: 4, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanRows(t, numberedRows(tt.rows), func(opts *Options) {
+				opts.SkipTop, opts.SkipBottom = tt.skipTop, tt.skipBottom
+			})
+			if got := len(result.Transactions); got != tt.want {
+				t.Errorf("transactions = %q, want %d", descriptions(result.Transactions), tt.want)
+			}
+			if got := len(result.Warnings); got != tt.warnings {
+				t.Errorf("warnings = %v, want %d", result.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestTrimRows(t *testing.T) {
+	tests := []struct {
+		name       string
+		rows       int
+		skipTop    int
+		skipBottom int
+		first      int
+		last       int
+	}{
+		{name: "default bottom trim", rows: 30, skipTop: 2, skipBottom: DefaultSkipBottom, first: 3, last: 16},
+		{name: "bottom trim only", rows: 20, skipBottom: 5, first: 1, last: 15},
+		{name: "one row left", rows: 20, skipTop: 10, skipBottom: 9, first: 11, last: 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanRows(t, numberedRows(tt.rows), func(opts *Options) {
+				opts.SkipTop, opts.SkipBottom = tt.skipTop, tt.skipBottom
+			})
+			var want []string
+			for i := tt.first; i <= tt.last; i++ {
+				want = append(want, fmt.Sprintf("row %d", i))
+			}
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("transactions = %q, want %q", got, want)
+			}
+			for i, transaction := range result.Transactions {
+				if transaction.Row != tt.first+i {
+					t.Errorf("%s has row number %d, want %d", transaction.Description, transaction.Row, tt.first+i)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderRow(t *testing.T) {
+	tests := []struct {
+		name       string
+		csv        string
+		mapHeaders bool
+		want       []string
+		headerRow  int
+	}{
+		{
+			name:      "template header is skipped",
+			csv:       templateRow("Date", "Description", "Amount") + templateRow("2024-01-02", "a", "-1"),
+			want:      []string{"a"},
+			headerRow: 1,
+		},
+		{
+			name:      "template without header keeps the first row",
+			csv:       templateRow("2024-01-02", "a", "-1") + templateRow("2024-01-03", "b", "2"),
+			want:      []string{"a", "b"},
+			headerRow: 0,
+		},
+		{
+			name:       "mapped header below a preamble",
+			csv:        "Statement,,\nDate,Description,Amount\n2024-01-02,a,-1\n",
+			mapHeaders: true,
+			want:       []string{"a"},
+			headerRow:  2,
+		},
+		{
+			name:       "mapped headers on a headerless sheet keep every row",
+			csv:        templateRow("2024-01-02", "a", "-1") + templateRow("2024-01-03", "b", "2"),
+			mapHeaders: true,
+			want:       []string{"a", "b"},
+			headerRow:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanCSV(t, tt.csv, func(opts *Options) { opts.MapHeaders = tt.mapHeaders })
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if got := result.Sheets[0].HeaderRow; got != tt.headerRow {
+				t.Errorf("header row = %d, want %d", got, tt.headerRow)
+			}
+		})
+	}
+}
+
+func TestTrimWhitespace(t *testing.T) {
+	csv := "Date,Description,Amount\n\u00a02024-01-02\t,\u00a0 Coffee\t\tshop\u00a0\u00a0Main St ,-3\n"
+	tests := []struct {
+		trim        bool
+		description string
+	}{
+		{trim: true, description: "Coffee shop Main St"},
+		{trim: false, description: "\u00a0 Coffee\t\tshop\u00a0\u00a0Main St "},
+	}
+	for _, tt := range tests {
+		result := cleanCSV(t, csv, func(opts *Options) {
+			opts.MapHeaders = true
+			opts.TrimWhitespace = tt.trim
+		})
+		if len(result.Transactions) != 1 {
+			t.Fatalf("trim %v: %d transactions, want 1", tt.trim, len(result.Transactions))
+		}
+		// The date is recognised either way, and written in DateFormat
+		got := result.Transactions[0]
+		if got.Date != "2024-01-02" || got.Description != tt.description {
+			t.Errorf("trim %v: date %q, description %q, want %q, %q", tt.trim, got.Date, got.Description, "2024-01-02", tt.description)
+		}
+	}
+}
+
+func TestCalculateFormulas(t *testing.T) {
+	for _, calculate := range []bool{false, true} {
+		f, err := testworkbook.New([][]string{
+			{"Date", "Description", "Amount", "Net"},
+			{"2024-01-02", "Coffee", "", "3.5"},
+			{"2024-01-03", "Salary", "", "-1000"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sheet := f.GetSheetName(0)
+		for _, cell := range []struct{ axis, formula string }{{"C2", "-D2"}, {"C3", "-D3"}} {
+			if err := f.SetCellFormula(sheet, cell.axis, cell.formula); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result := cleanWorkbookFile(t, f, func(opts *Options) {
+			opts.SkipTop, opts.SkipBottom = 0, 0
+			opts.MapHeaders = true
+			opts.CalculateFormulas = calculate
+		})
+		f.Close()
+
+		if !calculate {
+			if len(result.Transactions) != 0 {
+				t.Errorf("calculate false: formula amounts were read as %v", result.Transactions)
+			}
+			continue
+		}
+		want := []Transaction{{Description: "Coffee", Amount: 3.5, Type: Credit}, {Description: "Salary", Amount: 1000, Type: Debit}}
+		if len(result.Transactions) != len(want) {
+			t.Fatalf("calculate true: transactions = %q, want 2", descriptions(result.Transactions))
+		}
+		for i, got := range result.Transactions {
+			if got.Description != want[i].Description || got.Amount != want[i].Amount || got.Type != want[i].Type {
+				t.Errorf("calculate true: %s = %v %s, want %v %s", got.Description, got.Amount, got.Type, want[i].Amount, want[i].Type)
+			}
+		}
+	}
+}
+
+func TestMinColumns(t *testing.T) {
+	header := []string{"Date", "Description", "", "", "", "", "", "", "", "", "Amount"}
+	row := []string{"2024-01-02", "Coffee", "", "", "", "", "", "", "", "", "-3.50"}
+	tests := []struct {
+		name       string
+		rows       [][]string
+		minColumns int
+		want       []string
+		skipped    int
+	}{
+		{name: "amount at column 10", rows: [][]string{header, row}, want: []string{"Coffee"}},
+		{name: "row short of the amount", rows: [][]string{header, row, row[:7]}, want: []string{"Coffee"}, skipped: 1},
+		{name: "minimum below the amount column", rows: [][]string{header, row}, minColumns: 5, want: []string{"Coffee"}},
+		{name: "minimum beyond the row", rows: [][]string{header, row}, minColumns: 12, skipped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanRows(t, tt.rows, func(opts *Options) {
+				opts.SkipTop, opts.SkipBottom = 0, 0
+				opts.MapHeaders = true
+				opts.MinColumns = tt.minColumns
+			})
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if len(result.Skipped) != tt.skipped {
+				t.Fatalf("skipped = %v, want %d", result.Skipped, tt.skipped)
+			}
+			for _, skipped := range result.Skipped {
+				if skipped.Reason != SkipTooFewColumns {
+					t.Errorf("row %d skipped as %q, want %q", skipped.Row, skipped.Reason, SkipTooFewColumns)
+				}
+			}
+		})
+	}
+}
+
+func TestAmountSentinels(t *testing.T) {
+	french := "Date,Libellé,Montant\n2024-01-02,Café,-3\nDate,Libellé,Montant\n2024-01-03,Salaire,1000\n"
+	tests := []struct {
+		name      string
+		csv       string
+		configure func(*Options)
+		want      []string
+		skipped   int
+	}{
+		{
+			name: "French headers mapped by name",
+			csv:  french,
+			configure: func(opts *Options) {
+				opts.DateHeader, opts.DescriptionHeader, opts.AmountHeader = "Date", "Libellé", "Montant"
+			},
+			want: []string{"Café", "Salaire"},
+		},
+		{
+			name: "French label among several sentinels",
+			csv:  "Date,Description,Amount\n2024-01-02,Café,-3\nDate,Libellé,MONTANT\nDate,Details,Value\n2024-01-03,Salaire,1000\n",
+			configure: func(opts *Options) {
+				opts.AmountSentinels = []string{"Amount", "Value", "Montant"}
+			},
+			want: []string{"Café", "Salaire"},
+		},
+		{
+			name: "French label not configured",
+			csv:  "Date,Description,Amount\n2024-01-02,Café,-3\nDate,Libellé,Montant\n2024-01-03,Salaire,1000\n",
+			want: []string{"Café", "Salaire"}, skipped: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanCSV(t, tt.csv, func(opts *Options) {
+				opts.MapHeaders = true
+				if tt.configure != nil {
+					tt.configure(opts)
+				}
+			})
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if len(result.Skipped) != tt.skipped {
+				t.Errorf("skipped = %v, want %d", result.Skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestHeaderRows(t *testing.T) {
+	twoLine := templateRow("Posted", "Details", "Money") + templateRow("Date", "Description", "In/Out") +
+		templateRow("2024-01-02", "a", "-1") + templateRow("2024-01-03", "b", "2")
+	tests := []struct {
+		name       string
+		csv        string
+		headerRows int
+		mapHeaders bool
+		want       []string
+		skipped    int
+	}{
+		{name: "second header line read as data", csv: twoLine, want: []string{"a", "b"}, skipped: 1},
+		{name: "two-line header", csv: twoLine, headerRows: 2, want: []string{"a", "b"}},
+		{
+			name:       "two-line header matched on the second line",
+			csv:        "Account,,\nDate,Description,Amount\n2024-01-02,a,-1\n2024-01-03,b,2\n",
+			headerRows: 2,
+			mapHeaders: true,
+			want:       []string{"a", "b"},
+		},
+		{
+			name:       "three-line header",
+			csv:        templateRow("Statement", "", "") + twoLine,
+			headerRows: 3,
+			want:       []string{"a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanCSV(t, tt.csv, func(opts *Options) {
+				opts.HeaderRows = tt.headerRows
+				opts.MapHeaders = tt.mapHeaders
+			})
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if len(result.Skipped) != tt.skipped {
+				t.Errorf("skipped = %v, want %d", result.Skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestOnTransaction(t *testing.T) {
+	csv := "Date,Description,Amount\n2024-01-02,a,-1\n2024-01-03,b,200\nx,,not an amount\n2024-01-04,c,3\n2024-01-05,d,-4\n"
+	minAmount := 2.0
+	configure := func(opts *Options) {
+		opts.MapHeaders = true
+		opts.MinAmount = &minAmount
+	}
+	collected := cleanCSV(t, csv, configure)
+
+	var streamed []Transaction
+	result := cleanCSV(t, csv, func(opts *Options) {
+		configure(opts)
+		opts.OnTransaction = func(t Transaction) error {
+			streamed = append(streamed, t)
+			return nil
+		}
+	})
+	if got, want := descriptions(streamed), descriptions(collected.Transactions); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+	if len(result.Transactions) != 0 {
+		t.Errorf("streamed transactions were also kept: %v", result.Transactions)
+	}
+	if result.Summary.TransactionCount() != 3 || result.Summary.FilteredCount != 1 || result.Summary.SkippedCount != 1 {
+		t.Errorf("summary = %+v, want 3 transactions, 1 filtered and 1 skipped", result.Summary)
+	}
+	if result.Summary.CreditTotal != collected.Summary.CreditTotal || result.Summary.DebitTotal != collected.Summary.DebitTotal {
+		t.Errorf("totals = %v and %v, want %v and %v", result.Summary.CreditTotal, result.Summary.DebitTotal, collected.Summary.CreditTotal, collected.Summary.DebitTotal)
+	}
+
+	stop := errors.New("client went away")
+	calls := 0
+	opts := DefaultOptions()
+	opts.SkipTop, opts.SkipBottom, opts.MapHeaders = 0, 0, true
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts.OnTransaction = func(Transaction) error {
+		calls++
+		return stop
+	}
+	if _, err := CleanCSV(context.Background(), strings.NewReader(csv), opts); !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("callback error: got %v after %d calls, want %v after 1", err, calls, stop)
+	}
+
+	opts.Dedup = true
+	if _, err := CleanCSV(context.Background(), strings.NewReader(csv), opts); !errors.Is(err, ErrNotStreamable) {
+		t.Errorf("with Dedup: got %v, want %v", err, ErrNotStreamable)
+	}
+}
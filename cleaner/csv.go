@@ -0,0 +1,42 @@
+package cleaner
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+)
+
+// CSVSheetName is the sheet name reported for rows read from CSV input
+const CSVSheetName = "CSV"
+
+// CleanCSV reads CSV rows from r and applies the same trimming and
+// classification as Clean. The whole input is treated as a single sheet.
+func CleanCSV(ctx context.Context, r io.Reader, opts Options) (*Result, error) {
+	if opts.OnTransaction != nil && !opts.Streamable() {
+		return nil, ErrNotStreamable
+	}
+	sheets, err := selectSheets([]string{CSVSheetName}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, &FormatError{Err: err}
+	}
+
+	p := newProcessor(ctx, opts)
+	sheets = p.limitSheets(sheets)
+	p.sheetIndex, p.sheetCount = 1, len(sheets)
+	p.startSheet(CSVSheetName, rows, opts)
+	if len(sheets) > 0 && p.canTrim(CSVSheetName, rows) {
+		rows = rows[p.opts.SkipTop : len(rows)-p.opts.SkipBottom]
+		if err := p.processRows(CSVSheetName, rows); err != nil {
+			return nil, err
+		}
+	}
+	return p.finish()
+}
@@ -0,0 +1,64 @@
+package cleaner
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// DefaultDateFormat is the layout dates are normalized to
+const DefaultDateFormat = "2006-01-02"
+
+// dateLayouts are the formatted date values recognised in the date column.
+// "01-02-06" is the mm-dd-yy layout excelize uses for built-in date styles.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006/01/02",
+	"01-02-06",
+	"02 Jan 2006",
+	"2 Jan 2006",
+	"Jan 2, 2006",
+}
+
+// Largest serial number Excel accepts, 9999-12-31
+const maxExcelSerial = 2958465
+
+// ParseDateFormat converts a pattern written with YYYY, YY, MM and DD tokens,
+// such as "DD/MM/YYYY", into a Go time layout
+func ParseDateFormat(pattern string) string {
+	return strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02").Replace(pattern)
+}
+
+// isDecimal reports whether s is digits with at most one decimal point, the
+// only way a serial date is written. ParseFloat would also take signs,
+// exponents, hex floats and words like "NaN".
+func isDecimal(s string) bool {
+	digits, fraction, _ := strings.Cut(s, ".")
+	all := func(s string) bool { return strings.Trim(s, "0123456789") == "" }
+	return digits+fraction != "" && all(digits) && all(fraction)
+}
+
+// parseDate interprets a date cell, either an Excel serial number or one of
+// the recognised layouts. date1904 selects the 1904 serial date system.
+func parseDate(value string, date1904 bool) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if isDecimal(value) {
+		serial, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(serial) || serial <= 0 || serial > maxExcelSerial {
+			return time.Time{}, false
+		}
+		t, err := excelize.ExcelDateToTime(serial, date1904)
+		return t, err == nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
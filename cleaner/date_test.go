@@ -0,0 +1,36 @@
+package cleaner
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "45293", want: "2024-01-02"},
+		{in: "45293.5", want: "2024-01-02"},
+		{in: " 2024-01-02 ", want: "2024-01-02"},
+		{in: "02 Jan 2024", want: "2024-01-02"},
+		{in: "NaN"},
+		{in: "nan"},
+		{in: "Inf"},
+		{in: "0x10"},
+		{in: "4.5e4"},
+		{in: "-45293"},
+		{in: "0"},
+		{in: "."},
+		{in: "3000000"},
+	}
+	for _, tt := range tests {
+		got, ok := parseDate(tt.in, false)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("parseDate(%q) = %v, want no date", tt.in, got)
+			}
+			continue
+		}
+		if !ok || got.Format(DefaultDateFormat) != tt.want {
+			t.Errorf("parseDate(%q) = %v, %v, want %s", tt.in, got, ok, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package cleaner
+
+import "strings"
+
+// detectStart returns the number of rows above the start of a sheet's data
+// and whether the data starts with a header row. The header is the first
+// row naming AmountHeader when headers are matched by name. Otherwise the
+// data starts at the first row whose amount cell parses as a number, below
+// the row above it when that one looks like a header: it names a header or
+// holds no amount. Amounts are read from the column resolved for the sheet
+// in p.columns. ok is false when no start is found.
+func (p *processor) detectStart(rows [][]string) (skip int, header, ok bool) {
+	if p.opts.MapHeaders || p.opts.DetectAmountColumn {
+		for i, row := range rows {
+			if findHeader(row, p.opts.AmountHeader) >= 0 {
+				return i, true, true
+			}
+		}
+	}
+	for i, row := range rows {
+		if p.columns.amount < len(row) && p.isAmount(row[p.columns.amount]) {
+			if i > 0 && p.headerLike(rows[i-1]) {
+				return i - 1, true, true
+			}
+			return i, false, true
+		}
+	}
+	return 0, false, false
+}
+
+// headerLike reports whether row can be the header above the first data
+// row: it names one of the headers or has no amount in the amount column
+func (p *processor) headerLike(row []string) bool {
+	for _, name := range []string{p.opts.DateHeader, p.opts.DescriptionHeader, p.opts.AmountHeader} {
+		if findHeader(row, name) >= 0 {
+			return true
+		}
+	}
+	return p.columns.amount >= len(row) || !p.isAmount(row[p.columns.amount])
+}
+
+// isAmount reports whether cell holds a number in the configured format
+func (p *processor) isAmount(cell string) bool {
+	if strings.TrimSpace(cell) == "" {
+		return false
+	}
+	_, err := p.parseAmountCell(cell)
+	return err == nil
+}
+
+// detectFooter returns the number of trailing rows, below the header, that
+// hold no parseable amount in the p.columns of the sheet or are labelled as
+// totals
+func (p *processor) detectFooter(rows [][]string) int {
+	if p.opts.SkipTop >= len(rows) {
+		return 0
+	}
+	footer := 0
+	for i := len(rows) - 1; i > p.opts.SkipTop; i-- {
+		row := rows[i]
+		if p.columns.amount < len(row) && p.isAmount(row[p.columns.amount]) && !totalRow(row, p.columns) {
+			break
+		}
+		footer++
+	}
+	return footer
+}
+
+// totalRow reports whether row is a summary line labelled as a total. A
+// label in the description column only counts when the row has no date, so
+// a transaction described as "TOTAL WINE & MORE" is kept.
+func totalRow(row []string, columns columnIndices) bool {
+	for i, cell := range row {
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(cell)), "total") {
+			continue
+		}
+		if i != columns.description || columns.date >= len(row) || strings.TrimSpace(row[columns.date]) == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// sheetColumns resolves the columns of a sheet from the header that
+// processRows finds once the first skip rows are trimmed, or the default
+// positions when there is none
+func (p *processor) sheetColumns(rows [][]string, skip int) columnIndices {
+	var header []string
+	if skip < len(rows) {
+		if i := p.headerRow(rows[skip:]); i >= 0 {
+			header = rows[skip+i]
+		}
+	}
+	columns, _ := resolveColumns(header, p.opts)
+	return columns
+}
+
+// startSheet sets the rows trimmed from the top and bottom of the sheet
+// to those in opts, detecting them instead when Options.DetectStart or
+// Options.DetectFooter are set. What was detected is recorded in the
+// summary.
+func (p *processor) startSheet(sheet string, rows [][]string, opts Options) {
+	p.opts.SkipTop, p.opts.SkipBottom = opts.SkipTop, opts.SkipBottom
+	if p.opts.DetectStart {
+		p.columns = p.sheetColumns(rows, 0)
+		if detected, header, ok := p.detectStart(rows); ok {
+			p.opts.SkipTop = detected
+			if p.result.Summary.DataStart == nil {
+				p.result.Summary.DataStart = make(map[string]int)
+			}
+			// Counting from one, the first row kept is SkipTop+1, which
+			// is the header when there is one
+			p.result.Summary.DataStart[sheet] = detected + 1
+			if header {
+				p.result.Summary.DataStart[sheet]++
+			}
+		} else {
+			p.warn(sheet, "data start not detected, skipping the first %d rows", opts.SkipTop)
+		}
+	}
+	if p.opts.DetectFooter {
+		p.columns = p.sheetColumns(rows, p.opts.SkipTop)
+		p.opts.SkipBottom = p.detectFooter(rows)
+		if p.result.Summary.FooterRows == nil {
+			p.result.Summary.FooterRows = make(map[string]int)
+		}
+		p.result.Summary.FooterRows[sheet] = p.opts.SkipBottom
+		if n := p.opts.SkipBottom; n > 0 {
+			p.warn(sheet, "trimmed %d footer rows, rows %d to %d", n, len(rows)-n+1, len(rows))
+		}
+	}
+}
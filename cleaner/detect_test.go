@@ -0,0 +1,139 @@
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// cleanCSV cleans csv with untrimmed default options changed by configure.
+// Unresolved header names are not an error, as the result is still returned.
+func cleanCSV(t *testing.T, csv string, configure func(*Options)) *Result {
+	t.Helper()
+	opts := DefaultOptions()
+	opts.SkipTop, opts.SkipBottom = 0, 0
+	opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	if configure != nil {
+		configure(&opts)
+	}
+	result, err := CleanCSV(context.Background(), strings.NewReader(csv), opts)
+	var unresolved *UnresolvedHeadersError
+	if err != nil && !errors.As(err, &unresolved) {
+		t.Fatalf("CleanCSV: %v", err)
+	}
+	return result
+}
+
+// descriptions returns the descriptions of transactions in order
+func descriptions(transactions []Transaction) []string {
+	var got []string
+	for _, t := range transactions {
+		got = append(got, t.Description)
+	}
+	return got
+}
+
+func TestDetectFooter(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		want     []string
+		footer   int
+		warnings int
+	}{
+		{
+			name:   "total wine purchase is kept",
+			csv:    "Date,Description,Amount\n2024-01-02,Coffee,-3\n2024-01-03,TOTAL WINE & MORE,20.00\n",
+			want:   []string{"Coffee", "TOTAL WINE & MORE"},
+			footer: 0,
+		},
+		{
+			name:     "undated total line is trimmed",
+			csv:      "Date,Description,Amount\n2024-01-02,Coffee,-3\n,Total,-3\n",
+			want:     []string{"Coffee"},
+			footer:   1,
+			warnings: 1,
+		},
+		{
+			name:     "total label in the date column is trimmed",
+			csv:      "Date,Description,Amount\n2024-01-02,Coffee,-3\nTotals,,-3\n",
+			want:     []string{"Coffee"},
+			footer:   1,
+			warnings: 1,
+		},
+		{
+			name:     "rows without amounts are trimmed",
+			csv:      "Date,Description,Amount\n2024-01-02,Coffee,-3\nGenerated by the bank,,\n,,\n",
+			want:     []string{"Coffee"},
+			footer:   2,
+			warnings: 1,
+		},
+		{
+			name:     "header below a preamble line",
+			csv:      "Bank statement,,\nDate,Description,Amount\n2024-01-02,Coffee,-3\nGenerated by the bank,,\n",
+			want:     []string{"Coffee"},
+			footer:   1,
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanCSV(t, tt.csv, func(opts *Options) {
+				opts.MapHeaders = true
+				opts.DetectFooter = true
+			})
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if got := result.Summary.FooterRows[CSVSheetName]; got != tt.footer {
+				t.Errorf("footer rows = %d, want %d", got, tt.footer)
+			}
+			if got := len(result.Warnings); got != tt.warnings {
+				t.Errorf("warnings = %v, want %d", result.Warnings, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestDetectStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		csv       string
+		want      []string
+		dataStart int
+	}{
+		{
+			name:      "data in the first row",
+			csv:       templateRow("2024-01-02", "a", "-1") + templateRow("2024-01-03", "b", "2"),
+			want:      []string{"a", "b"},
+			dataStart: 1,
+		},
+		{
+			name: "header below a preamble",
+			csv: templateRow("Bank statement", "", "") + templateRow("Date", "Description", "Amount") +
+				templateRow("2024-01-02", "a", "-1"),
+			want:      []string{"a"},
+			dataStart: 3,
+		},
+		{
+			name:      "unlabelled line above the data",
+			csv:       templateRow("Account 1234", "", "") + templateRow("2024-01-02", "a", "-1"),
+			want:      []string{"a"},
+			dataStart: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := cleanCSV(t, tt.csv, func(opts *Options) { opts.DetectStart = true })
+			if got := descriptions(result.Transactions); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("transactions = %q, want %q", got, tt.want)
+			}
+			if got := result.Summary.DataStart[CSVSheetName]; got != tt.dataStart {
+				t.Errorf("data start = %d, want %d", got, tt.dataStart)
+			}
+		})
+	}
+}
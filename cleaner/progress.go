@@ -0,0 +1,20 @@
+package cleaner
+
+import "fmt"
+
+// Progress is an update sent on Options.Progress while cleaning
+type Progress struct {
+	Sheet string `json:"sheet"`
+
+	// SheetIndex is the 1-based position of Sheet among the SheetCount
+	// sheets selected for cleaning
+	SheetIndex int `json:"sheetIndex"`
+	SheetCount int `json:"sheetCount"`
+
+	// Row is the number of rows of the sheet processed so far
+	Row int `json:"row"`
+}
+
+func (p Progress) String() string {
+	return fmt.Sprintf("processing sheet %d of %d, row %d", p.SheetIndex, p.SheetCount, p.Row)
+}
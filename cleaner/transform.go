@@ -0,0 +1,115 @@
+package cleaner
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// dedupe drops exact duplicate transactions, keeping the first occurrence,
+// and returns the number removed
+func dedupe(transactions []Transaction) ([]Transaction, int) {
+	type key struct {
+		date, description string
+		amount            float64
+		typ               Type
+	}
+	seen := make(map[key]bool, len(transactions))
+	kept := transactions[:0]
+	for _, t := range transactions {
+		k := key{t.Date, t.Description, t.Amount, t.Type}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		kept = append(kept, t)
+	}
+	return kept, len(transactions) - len(kept)
+}
+
+// inAmountRange reports whether t's amount is neither below min nor above
+// max, either of which may be nil
+func inAmountRange(t Transaction, min, max *float64) bool {
+	return (min == nil || t.Amount >= *min) && (max == nil || t.Amount <= *max)
+}
+
+// filterAmounts drops the transactions with amounts below min or above max,
+// either of which may be nil, and returns the number removed
+func filterAmounts(transactions []Transaction, min, max *float64) ([]Transaction, int) {
+	kept := transactions[:0]
+	for _, t := range transactions {
+		if !inAmountRange(t, min, max) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept, len(transactions) - len(kept)
+}
+
+// SortField selects the key transactions are sorted by
+type SortField string
+
+const (
+	SortNone   SortField = ""
+	SortAmount SortField = "amount"
+	SortDate   SortField = "date"
+)
+
+// ParseSortField returns the SortField named by s
+func ParseSortField(s string) (SortField, error) {
+	switch field := SortField(s); field {
+	case SortNone, SortAmount, SortDate:
+		return field, nil
+	}
+	return "", fmt.Errorf("unknown sort field %q", s)
+}
+
+// sortTransactions stably sorts transactions by field. Transactions whose
+// date could not be parsed are placed last when sorting by date, in either
+// order.
+func sortTransactions(transactions []Transaction, field SortField, desc bool) {
+	if field == SortNone {
+		return
+	}
+	slices.SortStableFunc(transactions, func(a, b Transaction) int {
+		var c int
+		switch field {
+		case SortAmount:
+			c = cmp.Compare(a.Amount, b.Amount)
+		case SortDate:
+			if a.ParsedDate.IsZero() || b.ParsedDate.IsZero() {
+				return cmp.Compare(boolInt(a.ParsedDate.IsZero()), boolInt(b.ParsedDate.IsZero()))
+			}
+			c = a.ParsedDate.Compare(b.ParsedDate)
+		}
+		if desc {
+			c = -c
+		}
+		return c
+	})
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// runningBalance sets the Balance of each transaction in order, starting from
+// opening, and returns the closing balance. Debits add to the balance unless
+// creditsIncrease is set, in which case credits add and debits subtract.
+func runningBalance(transactions []Transaction, opening float64, creditsIncrease bool) float64 {
+	balance := opening
+	for i := range transactions {
+		t := &transactions[i]
+		if (t.Type == Credit) == creditsIncrease {
+			balance += t.Amount
+		} else {
+			balance -= t.Amount
+		}
+		b := balance
+		t.Balance = &b
+	}
+	return balance
+}
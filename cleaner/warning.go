@@ -0,0 +1,43 @@
+package cleaner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warning is a non-fatal problem found while cleaning, such as a sheet that
+// was skipped or a header that was not found
+type Warning struct {
+	Sheet   string `json:"sheet,omitempty"`
+	Message string `json:"message"`
+}
+
+// maxWarnings bounds the warnings kept in a result. Summary.WarningCount
+// still counts the ones dropped.
+const maxWarnings = 100
+
+// warn logs a warning about sheet, which may be empty for the whole input,
+// and records it in the result
+func (p *processor) warn(sheet, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if sheet == "" {
+		p.opts.Logger.Warn(message)
+	} else {
+		p.opts.Logger.Warn(message, "sheet", sheet)
+	}
+	p.result.Summary.WarningCount++
+	if len(p.result.Warnings) < maxWarnings {
+		p.result.Warnings = append(p.result.Warnings, Warning{Sheet: sheet, Message: message})
+	}
+}
+
+// warnTotals records the warnings that summarize the whole input: skipped
+// rows and header names that were not found
+func (p *processor) warnTotals() {
+	if n := len(p.result.Skipped); n > 0 {
+		p.warn("", "%d rows were skipped; see the skipped rows report", n)
+	}
+	if len(p.unresolved) > 0 {
+		p.warn("", "header names not found, default columns used: %s", strings.Join(p.unresolved, ", "))
+	}
+}
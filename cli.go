@@ -0,0 +1,128 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+// runCLI cleans the file at cfg.In without starting the server and writes
+// the output to cfg.Out, or stdout when that is empty or "-". cfg.Options
+// takes the query parameters of /upload, e.g. "skipTop=0&output=combined",
+// so both modes share the same options. Logs go to stderr. It returns the
+// process exit code.
+func runCLI() int {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	if err := cleanFile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// cleanFile does the work of runCLI
+func cleanFile() error {
+	query, err := url.ParseQuery(cfg.Options)
+	if err != nil {
+		return fmt.Errorf("invalid options %q: %v", cfg.Options, err)
+	}
+	r := &http.Request{URL: &url.URL{RawQuery: query.Encode()}}
+	opts, err := parseCleanOptions(r)
+	if err != nil {
+		return err
+	}
+	opts.Logger = slog.Default()
+	opts.Password = cfg.Password
+	outOpts, err := parseOutputOptions(r)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(cfg.In)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	format, err := detectFormat(file)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatUnknown:
+		return fmt.Errorf("%s is not an xlsx or xlsm workbook or CSV file", cfg.In)
+	case formatXLS:
+		return fmt.Errorf("%s is a legacy xls workbook, which is not supported; save it as xlsx", cfg.In)
+	case formatEncryptedXLSX:
+		if opts.Password == "" {
+			return fmt.Errorf("%s is password protected; provide it with -password", cfg.In)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pending := &pendingUpload{Filename: filepath.Base(cfg.In), Format: format, File: file, Options: opts, Logger: opts.Logger}
+	upload, err := pending.process(ctx)
+	if err != nil {
+		return err
+	}
+	if len(upload.Unresolved) > 0 {
+		slog.Warn("headers not found, default columns used", "headers", upload.Unresolved)
+	}
+
+	var out io.Writer = os.Stdout
+	var outFile *os.File
+	if cfg.Out != "" && cfg.Out != "-" {
+		if outFile, err = os.Create(cfg.Out); err != nil {
+			return err
+		}
+		defer outFile.Close()
+		out = outFile
+	}
+
+	if err := writeOutput(out, upload, outOpts); err != nil {
+		return err
+	}
+	if outFile != nil {
+		return outFile.Close()
+	}
+	return nil
+}
+
+// writeOutput writes the cleaned upload to out in the layout of
+// outOpts.Mode. As over HTTP, ofx, qif, xlsx and gzip mode only write the
+// first file, and ndjson is encoded straight to out.
+func writeOutput(out io.Writer, upload *cleanedUpload, outOpts outputOptions) error {
+	if outOpts.Mode == outputNDJSON {
+		return writeNDJSON(out, upload.Transactions, outOpts)
+	}
+	files, err := outputFiles(upload, outOpts)
+	if err != nil {
+		return fmt.Errorf("writing output: %v", err)
+	}
+
+	switch outOpts.Mode {
+	case outputOFX, outputQIF, outputXLSX:
+		_, err = out.Write(files[0].Data)
+		return err
+	case outputGzip:
+		gz := gzip.NewWriter(out)
+		if _, err := gz.Write(files[0].Data); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	archive, err := zipFiles(files)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(archive)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// columnLayout describes the output columns chosen with the columns
+// parameter, in place of date, description and amount. The resolved date,
+// description and amount columns are written cleaned; other columns are
+// copied from the source as they are.
+type columnLayout struct {
+	// names are the columns as requested, used for the header line
+	names  []string
+	first  cleaner.SheetInfo
+	sheets map[string]cleaner.SheetInfo
+}
+
+// newColumnLayout returns the layout of upload, or nil when it was cleaned
+// without a column selection
+func newColumnLayout(upload *cleanedUpload) *columnLayout {
+	if len(upload.Options.Columns) == 0 {
+		return nil
+	}
+	layout := &columnLayout{names: upload.Options.Columns, sheets: make(map[string]cleaner.SheetInfo)}
+	for _, sheet := range upload.Sheets {
+		layout.sheets[sheet.Name] = sheet
+	}
+	if len(upload.Sheets) > 0 {
+		layout.first = upload.Sheets[0]
+	}
+	return layout
+}
+
+// header returns the header names of the selected columns. The cleaned
+// columns are named as in the default layout, taking their positions from
+// the first sheet.
+func (l *columnLayout) header() []string {
+	header := make([]string, len(l.names))
+	first := l.first
+	for i, name := range l.names {
+		header[i] = name
+		if i >= len(first.Columns) {
+			continue
+		}
+		switch first.Columns[i] {
+		case first.DateColumn:
+			header[i] = "date"
+		case first.DescriptionColumn:
+			header[i] = "description"
+		case first.AmountColumn:
+			header[i] = "amount"
+		default:
+			if _, err := strconv.Atoi(name); err == nil {
+				header[i] = "column_" + name
+			}
+		}
+	}
+	return header
+}
+
+// cells returns the selected columns of t
+func (l *columnLayout) cells(t cleaner.Transaction, opts outputOptions) []string {
+	sheet := l.sheets[t.Sheet]
+	cells := make([]string, len(t.Columns))
+	for i, cell := range t.Columns {
+		cells[i] = cell
+		if i >= len(sheet.Columns) {
+			continue
+		}
+		switch sheet.Columns[i] {
+		case sheet.DateColumn:
+			cells[i] = t.Date
+		case sheet.DescriptionColumn:
+			cells[i] = t.Description
+		case sheet.AmountColumn:
+			cells[i] = formatAmount(t.Amount, opts)
+		}
+	}
+	return cells
+}
+
+// transactionHeader returns the header names of the main columns of
+// delimited output
+func transactionHeader(opts outputOptions) []string {
+	if opts.Layout != nil {
+		return opts.Layout.header()
+	}
+	return []string{"date", "description", "amount"}
+}
+
+// transactionCells returns the main columns of t for delimited output:
+// the selected columns, or date, description and amount
+func transactionCells(t cleaner.Transaction, opts outputOptions) []string {
+	if opts.Layout != nil {
+		return opts.Layout.cells(t, opts)
+	}
+	return []string{t.Date, t.Description, formatAmount(t.Amount, opts)}
+}
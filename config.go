@@ -0,0 +1,403 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAddr = ":6666"
+
+	// defaultMaxUploadBytes caps uploads at 50MB unless configured otherwise
+	defaultMaxUploadBytes = 50 << 20
+
+	// defaultShutdownTimeout is how long in-flight requests may run after a
+	// shutdown signal
+	defaultShutdownTimeout = 30 * time.Second
+
+	// Server timeouts guarding against slow and idle clients. Reading a
+	// request, the upload included, may take defaultReadTimeout, which
+	// allows a 50MB upload at under 1Mbit/s. net/http starts the write
+	// deadline once the request headers are read, not the body, so
+	// defaultWriteTimeout covers reading the upload, waiting for a
+	// processing slot and cleaning it.
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultReadTimeout       = 10 * time.Minute
+	defaultWriteTimeout      = 15 * time.Minute
+	defaultIdleTimeout       = 2 * time.Minute
+
+	// defaultProcessTimeout bounds the time spent cleaning a single upload
+	defaultProcessTimeout = 2 * time.Minute
+
+	// Uploads beyond defaultMaxConcurrent wait up to defaultQueueTimeout
+	// for a free processing slot
+	defaultMaxConcurrent = 4
+	defaultQueueTimeout  = 10 * time.Second
+
+	// defaultMaxJobs is the number of jobs that may hold an upload in
+	// memory, waiting or running, before new jobs are refused
+	defaultMaxJobs = 16
+
+	// defaultJobTTL is how long a job and its result are kept after it was
+	// created or finished
+	defaultJobTTL = time.Hour
+
+	// Responses replayed for a repeated Idempotency-Key are kept for
+	// defaultIdempotencyTTL, in at most defaultIdempotencyCacheBytes
+	defaultIdempotencyTTL        = 24 * time.Hour
+	defaultIdempotencyCacheBytes = 64 << 20
+
+	// defaultRateBurst is the number of requests a client may make at once
+	// when rate limiting is enabled
+	defaultRateBurst = 10
+
+	// defaultMaxSheets is the number of sheets of a workbook processed
+	// before the rest are left out
+	defaultMaxSheets = 100
+
+	// defaultTempTTL is the age at which spooled uploads left behind by a
+	// killed process are removed on startup
+	defaultTempTTL = time.Hour
+
+	// defaultResultsTTL is how long job archives are retained on disk
+	defaultResultsTTL = 30 * 24 * time.Hour
+)
+
+// config holds the server settings read from flags and the environment
+type config struct {
+	Addr            string
+	MaxUploadBytes  int64
+	ShutdownTimeout time.Duration
+	ProcessTimeout  time.Duration
+	MaxConcurrent   int
+	QueueTimeout    time.Duration
+	JobTTL          time.Duration
+
+	// MaxJobs caps the jobs waiting for or holding a processing slot, as
+	// each keeps its upload in memory until it finishes
+	MaxJobs int
+
+	// Timeouts of the HTTP server; zero disables one. WriteTimeout runs
+	// from the end of the request headers, so it must leave room for
+	// ReadTimeout, QueueTimeout and ProcessTimeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// IdempotencyTTL and IdempotencyCacheBytes bound the responses kept to
+	// answer repeated Idempotency-Keys. A zero size disables the cache.
+	IdempotencyTTL        time.Duration
+	IdempotencyCacheBytes int64
+
+	// ResultsDir, when set, retains the archive of every finished job for
+	// ResultsTTL
+	ResultsDir string
+	ResultsTTL time.Duration
+
+	// S3 sink that uploads are stored in with ?sink=s3. Credentials come
+	// from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	S3Endpoint string
+	S3Bucket   string
+	S3Prefix   string
+	S3Region   string
+
+	// TLSCert and TLSKey, when both set, serve HTTPS instead of plain
+	// HTTP. HTTPRedirectAddr optionally listens for plain HTTP and
+	// redirects it to HTTPS.
+	TLSCert          string
+	TLSKey           string
+	HTTPRedirectAddr string
+
+	// RateLimit is the sustained requests per second allowed from each
+	// client IP, with bursts of up to RateBurst. Zero disables limiting.
+	// Requests from TrustedProxies are attributed to the client in their
+	// X-Forwarded-For header.
+	RateLimit      float64
+	RateBurst      int
+	TrustedProxies []netip.Prefix
+
+	// APIKey, when set, must be presented by clients of the upload and job
+	// endpoints
+	APIKey string
+
+	// PprofAddr, when set, serves the pprof profiling endpoints on their
+	// own listener. It should be reachable from the internal network only.
+	PprofAddr string
+
+	// URLAllowedHosts are the hosts uploads may be fetched from by URL.
+	// Empty disables fetching.
+	URLAllowedHosts []string
+
+	// Debug includes internal error details in responses. They can reveal
+	// file paths and library internals, so it is meant for development.
+	Debug bool
+
+	// MaxSheets caps the sheets processed per workbook. Requests may lower
+	// it with maxSheets. Zero removes the cap.
+	MaxSheets int
+
+	// MaxRows caps the transactions read per upload. Requests may lower it
+	// with maxRows. Zero removes the cap.
+	MaxRows int
+
+	// CategoryRules is the CSV file of pattern,category rules used by
+	// output=summary-by-category. Without it every transaction is
+	// uncategorized.
+	CategoryRules string
+
+	// FormField is the multipart field uploads are read from
+	FormField string
+
+	// TempFiles spools xlsx uploads to disk instead of reading them in
+	// memory, in TempDir or the system's temporary directory. Spooled files
+	// older than TempTTL are removed on startup.
+	TempFiles bool
+	TempDir   string
+	TempTTL   time.Duration
+
+	// CORS origins and methods allowed for browser clients
+	CORSOrigins []string
+	CORSMethods []string
+
+	// In, when set, cleans that file from the command line instead of
+	// serving HTTP, writing the output to Out with the /upload query
+	// parameters in Options. Password decrypts a protected workbook.
+	In       string
+	Out      string
+	Options  string
+	Password string
+}
+
+var cfg = config{
+	Addr:            defaultAddr,
+	MaxUploadBytes:  defaultMaxUploadBytes,
+	ShutdownTimeout: defaultShutdownTimeout,
+	FormField:       "file",
+	RateBurst:       defaultRateBurst,
+	MaxSheets:       defaultMaxSheets,
+	ProcessTimeout:  defaultProcessTimeout,
+	MaxConcurrent:   defaultMaxConcurrent,
+	QueueTimeout:    defaultQueueTimeout,
+	JobTTL:          defaultJobTTL,
+	MaxJobs:         defaultMaxJobs,
+	ResultsTTL:      defaultResultsTTL,
+	TempTTL:         defaultTempTTL,
+	S3Region:        "us-east-1",
+	CORSOrigins:     []string{"*"},
+	CORSMethods:     []string{"POST"},
+
+	IdempotencyTTL:        defaultIdempotencyTTL,
+	IdempotencyCacheBytes: defaultIdempotencyCacheBytes,
+
+	ReadHeaderTimeout: defaultReadHeaderTimeout,
+	ReadTimeout:       defaultReadTimeout,
+	WriteTimeout:      defaultWriteTimeout,
+	IdleTimeout:       defaultIdleTimeout,
+}
+
+// loadConfig parses the command line flags. Each flag defaults to its
+// environment variable when set, so either can be used in deployments.
+func loadConfig() {
+	flag.StringVar(&cfg.Addr, "addr", envString("LISTEN_ADDR", cfg.Addr), "address to listen on")
+	flag.Int64Var(&cfg.MaxUploadBytes, "max-upload-bytes", envInt64("MAX_UPLOAD_BYTES", cfg.MaxUploadBytes), "maximum accepted upload size in bytes")
+	flag.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout), "time allowed for in-flight requests on shutdown")
+	flag.DurationVar(&cfg.ProcessTimeout, "process-timeout", envDuration("PROCESS_TIMEOUT", cfg.ProcessTimeout), "maximum time spent cleaning one upload")
+	flag.IntVar(&cfg.MaxConcurrent, "max-concurrent", int(envInt64("MAX_CONCURRENT", int64(cfg.MaxConcurrent))), "maximum uploads processed at once")
+	flag.IntVar(&cfg.MaxJobs, "max-jobs", int(envInt64("MAX_JOBS", int64(cfg.MaxJobs))), "maximum jobs queued or running at once")
+	flag.DurationVar(&cfg.QueueTimeout, "queue-timeout", envDuration("QUEUE_TIMEOUT", cfg.QueueTimeout), "how long an upload waits for a free processing slot")
+	flag.DurationVar(&cfg.ReadHeaderTimeout, "read-header-timeout", envDuration("READ_HEADER_TIMEOUT", cfg.ReadHeaderTimeout), "time allowed to read request headers")
+	flag.DurationVar(&cfg.ReadTimeout, "read-timeout", envDuration("READ_TIMEOUT", cfg.ReadTimeout), "time allowed to read a whole request, upload included")
+	flag.DurationVar(&cfg.WriteTimeout, "write-timeout", envDuration("WRITE_TIMEOUT", cfg.WriteTimeout), "time allowed to read, process and answer a request, from the end of its headers")
+	flag.DurationVar(&cfg.IdleTimeout, "idle-timeout", envDuration("IDLE_TIMEOUT", cfg.IdleTimeout), "how long idle keep-alive connections are kept open")
+	flag.DurationVar(&cfg.JobTTL, "job-ttl", envDuration("JOB_TTL", cfg.JobTTL), "how long finished jobs are kept")
+	flag.DurationVar(&cfg.IdempotencyTTL, "idempotency-ttl", envDuration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL), "how long responses are replayed for a repeated Idempotency-Key")
+	flag.Int64Var(&cfg.IdempotencyCacheBytes, "idempotency-cache-bytes", envInt64("IDEMPOTENCY_CACHE_BYTES", cfg.IdempotencyCacheBytes), "memory for responses kept per Idempotency-Key, 0 to disable")
+	flag.StringVar(&cfg.ResultsDir, "results-dir", envString("RESULTS_DIR", cfg.ResultsDir), "directory job archives are retained in, disabled when empty")
+	flag.DurationVar(&cfg.ResultsTTL, "results-ttl", envDuration("RESULTS_TTL", cfg.ResultsTTL), "how long retained job archives are kept")
+	flag.StringVar(&cfg.S3Endpoint, "s3-endpoint", envString("S3_ENDPOINT", cfg.S3Endpoint), "S3 compatible endpoint URL, AWS when empty")
+	flag.StringVar(&cfg.S3Bucket, "s3-bucket", envString("S3_BUCKET", cfg.S3Bucket), "bucket for the S3 sink, disabled when empty")
+	flag.StringVar(&cfg.S3Prefix, "s3-prefix", envString("S3_PREFIX", cfg.S3Prefix), "key prefix for objects written to the S3 sink")
+	flag.StringVar(&cfg.S3Region, "s3-region", envString("S3_REGION", cfg.S3Region), "region used to sign S3 requests")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", envString("TLS_CERT_FILE", cfg.TLSCert), "TLS certificate file, serving HTTPS when set with -tls-key")
+	flag.StringVar(&cfg.TLSKey, "tls-key", envString("TLS_KEY_FILE", cfg.TLSKey), "TLS private key file")
+	flag.StringVar(&cfg.HTTPRedirectAddr, "http-redirect-addr", envString("HTTP_REDIRECT_ADDR", cfg.HTTPRedirectAddr), "address redirecting plain HTTP to HTTPS, disabled when empty")
+	flag.Float64Var(&cfg.RateLimit, "rate-limit", envFloat64("RATE_LIMIT", cfg.RateLimit), "requests per second allowed from each client IP, 0 to disable")
+	flag.IntVar(&cfg.RateBurst, "rate-burst", int(envInt64("RATE_BURST", int64(cfg.RateBurst))), "requests a client IP may burst above the rate limit")
+	trustedProxies := flag.String("trusted-proxies", envString("TRUSTED_PROXIES", ""), "comma separated proxy IPs or CIDRs whose X-Forwarded-For is trusted")
+	flag.StringVar(&cfg.CategoryRules, "category-rules", envString("CATEGORY_RULES", cfg.CategoryRules), "CSV file of pattern,category rules for output=summary-by-category")
+	flag.StringVar(&cfg.APIKey, "api-key", envString("API_KEY", cfg.APIKey), "API key required on upload and job endpoints, disabled when empty")
+	flag.StringVar(&cfg.PprofAddr, "pprof-addr", envString("PPROF_ADDR", cfg.PprofAddr), "address serving pprof profiles, e.g. localhost:6060, disabled when empty")
+	urlAllowedHosts := flag.String("url-allowed-hosts", envString("URL_ALLOWED_HOSTS", ""), "comma separated hosts uploads may be fetched from with the url field, disabled when empty")
+	flag.BoolVar(&cfg.Debug, "debug", envBool("DEBUG", cfg.Debug), "include internal error details in responses, not for production")
+	flag.IntVar(&cfg.MaxRows, "max-rows", int(envInt64("MAX_ROWS", int64(cfg.MaxRows))), "maximum transactions read per upload, 0 for no limit")
+	flag.IntVar(&cfg.MaxSheets, "max-sheets", int(envInt64("MAX_SHEETS", int64(cfg.MaxSheets))), "maximum sheets processed per workbook, 0 for no limit")
+	flag.StringVar(&cfg.FormField, "form-field", envString("FORM_FIELD", cfg.FormField), "multipart form field uploads are read from")
+	flag.BoolVar(&cfg.TempFiles, "temp-files", envBool("TEMP_FILES", cfg.TempFiles), "spool xlsx uploads to temporary files instead of memory")
+	flag.StringVar(&cfg.TempDir, "temp-dir", envString("TEMP_DIR", cfg.TempDir), "directory uploads are spooled to, the system temporary directory when empty")
+	flag.DurationVar(&cfg.TempTTL, "temp-ttl", envDuration("TEMP_TTL", cfg.TempTTL), "age at which leftover spooled uploads are removed on startup")
+	corsOrigins := flag.String("cors-origins", envString("CORS_ALLOWED_ORIGINS", strings.Join(cfg.CORSOrigins, ",")), "comma separated origins allowed by CORS")
+	corsMethods := flag.String("cors-methods", envString("CORS_ALLOWED_METHODS", strings.Join(cfg.CORSMethods, ",")), "comma separated methods allowed by CORS")
+	flag.StringVar(&cfg.In, "in", "", "file to clean from the command line instead of starting the server")
+	flag.StringVar(&cfg.Out, "out", "", "where -in writes its output, stdout when empty or -")
+	flag.StringVar(&cfg.Options, "options", "", "/upload query parameters applied to -in, e.g. skipTop=0&output=combined")
+	flag.StringVar(&cfg.Password, "password", envString("WORKBOOK_PASSWORD", ""), "password of a protected -in workbook")
+	flag.Parse()
+
+	if cfg.MaxConcurrent <= 0 {
+		log.Fatalf("max concurrent uploads must be positive, got %d", cfg.MaxConcurrent)
+	}
+	if cfg.MaxJobs <= 0 {
+		log.Fatalf("max jobs must be positive, got %d", cfg.MaxJobs)
+	}
+	if origins := parseList(*corsOrigins); len(origins) > 0 {
+		cfg.CORSOrigins = origins
+	}
+	if methods := parseList(strings.ToUpper(*corsMethods)); len(methods) > 0 {
+		cfg.CORSMethods = methods
+	}
+
+	if cfg.MaxRows < 0 {
+		log.Fatalf("max rows must not be negative, got %d", cfg.MaxRows)
+	}
+	if cfg.MaxSheets < 0 {
+		log.Fatalf("max sheets must not be negative, got %d", cfg.MaxSheets)
+	}
+	if cfg.MaxUploadBytes <= 0 {
+		log.Fatalf("max upload size must be positive, got %d", cfg.MaxUploadBytes)
+	}
+	if cfg.ReadHeaderTimeout < 0 || cfg.ReadTimeout < 0 || cfg.WriteTimeout < 0 || cfg.IdleTimeout < 0 {
+		log.Fatalf("server timeouts must not be negative")
+	}
+	if cfg.WriteTimeout > 0 && cfg.ReadTimeout == 0 {
+		log.Fatalf("a write timeout requires a read timeout, as it includes reading the upload")
+	}
+	if need := cfg.ReadTimeout + cfg.QueueTimeout + cfg.ProcessTimeout; cfg.WriteTimeout > 0 && cfg.WriteTimeout < need {
+		log.Fatalf("write timeout %s must be at least the read, queue and process timeouts, %s", cfg.WriteTimeout, need)
+	}
+	if cfg.JobTTL <= 0 {
+		log.Fatalf("job ttl must be positive, got %s", cfg.JobTTL)
+	}
+	if cfg.RateLimit < 0 || (cfg.RateLimit > 0 && cfg.RateBurst <= 0) {
+		log.Fatalf("rate limit must not be negative and its burst must be positive")
+	}
+	for _, proxy := range parseList(*trustedProxies) {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				log.Fatalf("invalid trusted proxy %q: %v", proxy, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+	cfg.URLAllowedHosts = parseList(strings.ToLower(*urlAllowedHosts))
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		log.Fatalf("tls cert and key must be set together")
+	}
+	if cfg.HTTPRedirectAddr != "" && cfg.TLSCert == "" {
+		log.Fatalf("http redirect requires tls to be configured")
+	}
+	if cfg.IdempotencyCacheBytes < 0 || cfg.IdempotencyTTL <= 0 {
+		log.Fatalf("idempotency cache size must not be negative and its ttl must be positive")
+	}
+	if cfg.S3Endpoint != "" {
+		if u, err := url.Parse(cfg.S3Endpoint); err != nil || u.Host == "" {
+			log.Fatalf("invalid s3 endpoint %q", cfg.S3Endpoint)
+		}
+	}
+	if cfg.TempTTL <= cfg.ProcessTimeout {
+		log.Fatalf("temp ttl %s must exceed the process timeout %s", cfg.TempTTL, cfg.ProcessTimeout)
+	}
+	if cfg.TempDir != "" {
+		if err := os.MkdirAll(cfg.TempDir, 0o700); err != nil {
+			log.Fatalf("creating temp directory: %v", err)
+		}
+	}
+	if cfg.CategoryRules != "" {
+		rules, err := loadCategoryRules(cfg.CategoryRules)
+		if err != nil {
+			log.Fatalf("loading category rules: %v", err)
+		}
+		categoryRules = rules
+	}
+	if cfg.ResultsDir != "" {
+		if cfg.ResultsTTL <= 0 {
+			log.Fatalf("results ttl must be positive, got %s", cfg.ResultsTTL)
+		}
+		if err := os.MkdirAll(cfg.ResultsDir, 0o700); err != nil {
+			log.Fatalf("creating results directory: %v", err)
+		}
+	}
+}
+
+// envString returns the environment variable key, or def when it is unset
+func envString(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
+
+// envInt64 returns the integer value of the environment variable key, or def
+// when it is unset
+func envInt64(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", key, value, err)
+	}
+	return n
+}
+
+// envFloat64 returns the numeric value of the environment variable key, or
+// def when it is unset
+func envFloat64(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", key, value, err)
+	}
+	return f
+}
+
+// envBool returns the boolean value of the environment variable key, or def
+// when it is unset
+func envBool(key string, def bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", key, value, err)
+	}
+	return b
+}
+
+// envDuration returns the duration value of the environment variable key, or
+// def when it is unset
+func envDuration(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v", key, value, err)
+	}
+	return d
+}
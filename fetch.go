@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// urlFetchTimeout bounds downloading an upload given by URL
+const urlFetchTimeout = 2 * time.Minute
+
+// allowedURL reports whether u may be fetched: http or https on one of
+// cfg.URLAllowedHosts, with no credentials
+func allowedURL(u *url.URL) bool {
+	return (u.Scheme == "http" || u.Scheme == "https") && u.User == nil &&
+		slices.Contains(cfg.URLAllowedHosts, strings.ToLower(u.Hostname()))
+}
+
+// urlClient fetches uploads, refusing redirects off the allowlist
+var urlClient = &http.Client{
+	Timeout: urlFetchTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("too many redirects")
+		}
+		if !allowedURL(req.URL) {
+			return fmt.Errorf("redirect to %s is not allowed", req.URL.Host)
+		}
+		return nil
+	},
+}
+
+// fetchUpload downloads the spreadsheet at rawURL, limited to
+// cfg.MaxUploadBytes, and detects its format like an uploaded file. Only
+// hosts in cfg.URLAllowedHosts are contacted, so the server cannot be used
+// to reach arbitrary internal addresses. Failures are returned as an
+// *uploadError.
+func fetchUpload(ctx context.Context, rawURL string, opts cleaner.Options) (*pendingUpload, error) {
+	if len(cfg.URLAllowedHosts) == 0 {
+		return nil, &uploadError{http.StatusBadRequest, "Uploading from a URL is not enabled"}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || !allowedURL(u) {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("URL %q is not on an allowed host", rawURL)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Invalid URL %q", rawURL)}
+	}
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		opts.Logger.Warn("fetching upload failed", "url", u.Redacted(), "error", err)
+		return nil, &uploadError{http.StatusBadGateway, errorDetail("Unable to fetch the URL", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &uploadError{http.StatusBadGateway, fmt.Sprintf("Fetching the URL returned %s", resp.Status)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, cfg.MaxUploadBytes+1))
+	if err != nil {
+		opts.Logger.Warn("reading fetched upload failed", "url", u.Redacted(), "error", err)
+		return nil, &uploadError{http.StatusBadGateway, errorDetail("Unable to fetch the URL", err)}
+	}
+	if int64(len(data)) > cfg.MaxUploadBytes {
+		return nil, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", cfg.MaxUploadBytes)}
+	}
+
+	filename := path.Base(u.Path)
+	if filename == "/" || filename == "." {
+		filename = ""
+	}
+	file := bytes.NewReader(data)
+	format, err := detectFormat(file)
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "Unable to read uploaded file"}
+	}
+	if format == formatUnknown {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Fetched file %q is not an xlsx or xlsm workbook or CSV file", filename)}
+	}
+	if format == formatXLS {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Fetched file %q is a legacy xls workbook, which is not supported; save it as xlsx", filename)}
+	}
+	if format == formatEncryptedXLSX && opts.Password == "" {
+		return nil, &uploadError{http.StatusBadRequest, "Workbook is password protected; provide it in the password field"}
+	}
+	return &pendingUpload{Filename: filename, Format: format, File: file, Options: opts, Logger: opts.Logger}, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin/cleaner"
+	"github.com/gin-gonic/gin/internal/testworkbook"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// templateRow returns a row of the standard template, with the date,
+// description and amount in their default columns
+func templateRow(date, description, amount string) []string {
+	row := make([]string, cleaner.DefaultAmountColumn+1)
+	row[cleaner.DefaultDateColumn] = date
+	row[cleaner.DefaultDescriptionColumn] = description
+	row[cleaner.DefaultAmountColumn] = amount
+	return row
+}
+
+func TestGoldenSplitOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		rows  [][]string
+	}{
+		{
+			name:  "template",
+			query: "skipTop=2&skipBottom=1",
+			rows: [][]string{
+				{"Bank statement"},
+				{"Account 1234"},
+				templateRow("Date", "Description", "Amount"),
+				templateRow("02/01/2024", "Coffee", "-3.50"),
+				templateRow("03/01/2024", "Salary", "2500"),
+				templateRow("04/01/2024", "Refund", "-12.25"),
+				templateRow("", "Closing balance", "2484.25"),
+			},
+		},
+		{
+			name:  "mapped",
+			query: "skipTop=0&skipBottom=0&dateHeader=Posted&descriptionHeader=Details&amountHeader=Value",
+			rows: [][]string{
+				{"Posted", "Details", "Value"},
+				{"2024-02-01", "Rent", "$1,250.00"},
+				{"2024-02-02", "Groceries", "(45.10)"},
+				{"2024-02-03", "Interest", "0.42 CR"},
+				{"2024-02-04", "Transfer", "100.00 DR"},
+			},
+		},
+		{
+			name:  "eu",
+			query: "skipTop=0&skipBottom=0&mapHeaders=true&numberFormat=eu",
+			rows: [][]string{
+				{"Date", "Description", "Amount"},
+				{"2024-03-01", "Loyer", "-1.250,00"},
+				{"2024-03-02", "Salaire", "2.300,55"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := testworkbook.New(tt.rows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r := httptest.NewRequest("POST", "/upload?"+tt.query, nil)
+			opts, err := parseCleanOptions(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outOpts, err := parseOutputOptions(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := cleaner.CleanFile(context.Background(), f, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := outputFiles(&cleanedUpload{Result: result, Options: opts}, outOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, file := range files[:2] {
+				path := filepath.Join("testdata", "golden", tt.name, file.Name)
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(path, file.Data, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(file.Data, want) {
+					t.Errorf("%s:\ngot\n%s\nwant\n%s", file.Name, file.Data, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cachedResponse is a response recorded for an Idempotency-Key
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+
+	// done is closed once the response is recorded. Until then the entry
+	// only marks the key as in flight.
+	done chan struct{}
+}
+
+// idempotencyCache holds recorded responses until they expire or the cache
+// grows past cfg.IdempotencyCacheBytes, when the oldest are evicted
+type idempotencyCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+	order   []string
+	size    int
+}
+
+var idempotency = &idempotencyCache{entries: make(map[string]*cachedResponse)}
+
+// begin returns the response recorded for key, or reserves key and returns
+// nil. inFlight reports that another request holds the key.
+func (c *idempotencyCache) begin(key string) (cached *cachedResponse, inFlight bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		select {
+		case <-entry.done:
+			if time.Now().Before(entry.expires) {
+				return entry, false
+			}
+			c.remove(key)
+		default:
+			return nil, true
+		}
+	}
+	c.entries[key] = &cachedResponse{done: make(chan struct{})}
+	return nil, false
+}
+
+// finish records the response for a key reserved by begin, or releases the
+// key when the response should not be replayed
+func (c *idempotencyCache) finish(key string, status int, header http.Header, body []byte, keep bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entries[key]
+	close(entry.done)
+	if !keep || len(body) > int(cfg.IdempotencyCacheBytes) {
+		delete(c.entries, key)
+		return
+	}
+
+	entry.status, entry.header, entry.body = status, header, body
+	entry.expires = time.Now().Add(cfg.IdempotencyTTL)
+	c.order = append(c.order, key)
+	c.size += len(body)
+	for c.size > int(cfg.IdempotencyCacheBytes) && len(c.order) > 0 {
+		c.remove(c.order[0])
+	}
+}
+
+// remove drops a recorded response. The caller holds c.mu.
+func (c *idempotencyCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			c.size -= len(entry.body)
+			break
+		}
+	}
+}
+
+// recorder copies a response into a buffer as it is written
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *recorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// idempotent replays the recorded response when a request repeats the
+// Idempotency-Key of an earlier one to the same endpoint, instead of
+// processing the upload again. Server errors are not recorded, so those
+// requests can be retried. Requests without the header are passed through.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || cfg.IdempotencyCacheBytes == 0 {
+			next(w, r)
+			return
+		}
+		key = r.Method + " " + r.URL.Path + " " + key
+
+		cached, inFlight := idempotency.begin(key)
+		if inFlight {
+			writeError(w, r, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+			return
+		}
+		if cached != nil {
+			for name, values := range cached.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(cached.status)
+			w.Write(cached.body)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		keep := false
+		defer func() {
+			idempotency.finish(key, rec.status, recordedHeader(w.Header()), rec.body.Bytes(), keep)
+		}()
+		next(rec, r)
+		keep = rec.status != 0 && rec.status < http.StatusInternalServerError
+	}
+}
+
+// recordedHeader copies the response header for replay. The body is recorded
+// before compressJSON encodes it, so the headers describing the encoding are
+// left out and set again for each replay.
+func recordedHeader(header http.Header) http.Header {
+	header = header.Clone()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Del("Vary")
+	return header
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdempotentReplayWithCompression(t *testing.T) {
+	cfg.IdempotencyCacheBytes = 1 << 20
+	cfg.IdempotencyTTL = time.Minute
+	idempotency = &idempotencyCache{entries: make(map[string]*cachedResponse)}
+
+	calls := 0
+	handler := compressJSON(idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok":true}`)
+	}))
+
+	tests := []struct {
+		name     string
+		gzip     bool
+		replayed bool
+	}{
+		{"first request compressed", true, false},
+		{"replay compressed", true, true},
+		{"replay uncompressed", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/upload", nil)
+			req.Header.Set("Idempotency-Key", "key")
+			if tt.gzip {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Idempotent-Replayed") == "true"; got != tt.replayed {
+				t.Errorf("replayed = %v, want %v", got, tt.replayed)
+			}
+			encoding := rec.Header().Get("Content-Encoding")
+			body := io.Reader(rec.Body)
+			if tt.gzip {
+				if encoding != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+				}
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("body is not gzip: %v", err)
+				}
+				body = gz
+			} else if encoding != "" {
+				t.Fatalf("Content-Encoding = %q, want none", encoding)
+			}
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != `{"ok":true}` {
+				t.Errorf("body = %q", data)
+			}
+			if vary := rec.Header().Values("Vary"); len(vary) != 1 {
+				t.Errorf("Vary = %q, want one Accept-Encoding", vary)
+			}
+		})
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
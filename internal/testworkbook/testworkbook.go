@@ -0,0 +1,29 @@
+// Package testworkbook builds in-memory workbooks for tests.
+package testworkbook
+
+import "github.com/xuri/excelize/v2"
+
+// New builds an in-memory workbook whose first sheet holds rows, one string
+// cell per value, so statements can be constructed without a file on disk
+// and passed to cleaner.CleanFile. Empty values leave the cell unset.
+func New(rows [][]string) (*excelize.File, error) {
+	f := excelize.NewFile()
+	sheet := f.GetSheetName(0)
+	for r, row := range rows {
+		for c, value := range row {
+			if value == "" {
+				continue
+			}
+			cell, err := excelize.CoordinatesToCellName(c+1, r+1)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			if err := f.SetCellValue(sheet, cell, value); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// jobStatus is the state of an asynchronous cleaning job
+type jobStatus string
+
+const (
+	jobQueued  jobStatus = "queued"
+	jobRunning jobStatus = "running"
+	jobDone    jobStatus = "done"
+	jobFailed  jobStatus = "failed"
+)
+
+// job is an upload cleaned in the background. The exported fields are
+// reported by GET /jobs/{id}.
+type job struct {
+	ID                string           `json:"id"`
+	Status            jobStatus        `json:"status"`
+	Error             string           `json:"error,omitempty"`
+	Summary           *cleaner.Summary `json:"summary,omitempty"`
+	UnresolvedHeaders []string         `json:"unresolvedHeaders,omitempty"`
+	CreatedAt         time.Time        `json:"createdAt"`
+	ExpiresAt         time.Time        `json:"expiresAt"`
+
+	// errorStatus is the HTTP status of a failed job
+	errorStatus int
+	filename    string
+	archive     []byte
+}
+
+// jobStore holds jobs in memory until they expire
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+var jobs = &jobStore{jobs: make(map[string]*job)}
+
+// add registers a new queued job and returns a copy of it
+func (s *jobStore) add(filename string) job {
+	now := time.Now()
+	j := &job{
+		ID:        randomID(16),
+		Status:    jobQueued,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.JobTTL),
+		filename:  filename,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	return *j
+}
+
+// get returns a copy of the job with the given ID, reporting false when it
+// does not exist or has expired
+func (s *jobStore) get(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok || time.Now().After(j.ExpiresAt) {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// update applies fn to the job with the given ID
+func (s *jobStore) update(id string, fn func(*job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		fn(j)
+	}
+}
+
+// expire removes expired jobs every interval until ctx is done
+func (s *jobStore) expire(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for id, j := range s.jobs {
+				if now.After(j.ExpiresAt) {
+					delete(s.jobs, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// runJob cleans the upload once a processing slot is free and stores the
+// zip archive, or the failure, on the job. It releases the job slot taken by
+// createJobHandler.
+func runJob(id string, pending *pendingUpload, outOpts outputOptions) {
+	defer releaseJobSlot()
+	processingSlots <- struct{}{}
+	defer releaseSlot()
+	jobs.update(id, func(j *job) { j.Status = jobRunning })
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ProcessTimeout)
+	defer cancel()
+
+	fail := func(status int, message string) {
+		jobs.update(id, func(j *job) {
+			j.Status = jobFailed
+			j.Error = message
+			j.errorStatus = status
+			j.ExpiresAt = time.Now().Add(cfg.JobTTL)
+		})
+	}
+
+	upload, err := pending.clean(ctx)
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		fail(uploadErr.Status, uploadErr.Message)
+		return
+	}
+	if err != nil {
+		pending.Logger.Error("processing job", "job", id, "error", err)
+		fail(http.StatusInternalServerError, errorDetail("Error processing file", err))
+		return
+	}
+
+	files, err := outputFiles(upload, outOpts)
+	if errors.As(err, &uploadErr) {
+		fail(uploadErr.Status, uploadErr.Message)
+		return
+	}
+	if err == nil {
+		var archive []byte
+		if archive, err = zipFiles(files); err == nil {
+			if cfg.ResultsDir != "" {
+				if err := saveResult(id, archive); err != nil {
+					pending.Logger.Error("saving job result", "job", id, "error", err)
+				}
+			}
+			jobs.update(id, func(j *job) {
+				j.Status = jobDone
+				j.Summary = &upload.Summary
+				j.UnresolvedHeaders = upload.Unresolved
+				j.archive = archive
+				j.ExpiresAt = time.Now().Add(cfg.JobTTL)
+			})
+			return
+		}
+	}
+	pending.Logger.Error("writing job result", "job", id, "error", err)
+	fail(http.StatusInternalServerError, errorDetail("Error creating zip file", err))
+}
+
+// createJobHandler accepts an upload like /upload and cleans it in the
+// background, answering 202 with the job to poll
+func createJobHandler(w http.ResponseWriter, r *http.Request) {
+	outOpts, err := parseOutputOptions(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pending := readUpload(w, r)
+	if pending == nil {
+		return
+	}
+	// Each queued job holds its upload in memory, so their number is capped
+	if !acquireJobSlot() {
+		pending.Close()
+		uploadFailuresTotal.Inc()
+		setRetryAfter(w)
+		writeError(w, r, "Too many jobs are queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	// The form file goes away with the request, so keep the upload in memory
+	if err := pending.buffer(); err != nil {
+		releaseJobSlot()
+		pending.Close()
+		uploadFailuresTotal.Inc()
+		writeError(w, r, "Unable to read uploaded file", http.StatusBadRequest)
+		return
+	}
+
+	recordUpload(r.Context(), pending.Filename, 0)
+	j := jobs.add(pending.Filename)
+	pending.Logger.Info("job created", "job", j.ID, "filename", pending.Filename)
+	go runJob(j.ID, pending, outOpts)
+
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, j)
+}
+
+// jobStatusHandler reports the status of a job
+func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, j)
+}
+
+// jobResultHandler downloads the zip archive of a finished job. A failed job
+// answers with the error it failed with.
+func jobResultHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, "Job not found", http.StatusNotFound)
+		return
+	}
+	switch j.Status {
+	case jobDone:
+		setSummaryHeaders(w, *j.Summary)
+		writeZip(w, outputFilename(j.filename, ".zip"), j.archive)
+	case jobFailed:
+		writeError(w, r, j.Error, j.errorStatus)
+	default:
+		writeError(w, r, "Job has not finished", http.StatusConflict)
+	}
+}
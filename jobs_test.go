@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateJobQueueFull(t *testing.T) {
+	// Take every processing slot, so the job stays queued, and all job
+	// slots but one
+	for range cap(processingSlots) {
+		processingSlots <- struct{}{}
+	}
+	for range cap(jobSlots) - 1 {
+		jobSlots <- struct{}{}
+	}
+	defer func() {
+		for range cap(jobSlots) - 1 {
+			releaseJobSlot()
+		}
+		for range cap(processingSlots) {
+			releaseSlot()
+		}
+	}()
+
+	rec := serve(createJobHandler, newUploadRequest(t, "/jobs"+statementQuery, "a.csv", []byte(statementCSV)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("first job: status %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+
+	rec = serve(createJobHandler, newUploadRequest(t, "/jobs"+statementQuery, "b.csv", []byte(statementCSV)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("second job: status %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("second job: no Retry-After header")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// processingSlots bounds the number of uploads cleaned at once. It is sized
+// from cfg.MaxConcurrent at startup.
+var processingSlots chan struct{}
+
+// jobSlots bounds the number of jobs queued or running, each holding its
+// buffered upload. It is sized from cfg.MaxJobs at startup.
+var jobSlots chan struct{}
+
+// acquireJobSlot takes a job slot without waiting, reporting false when
+// cfg.MaxJobs jobs are already queued or running
+func acquireJobSlot() bool {
+	select {
+	case jobSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseJobSlot frees a slot taken by acquireJobSlot
+func releaseJobSlot() {
+	<-jobSlots
+}
+
+// setRetryAfter tells a client turned away because the server is busy to
+// retry after about cfg.QueueTimeout
+func setRetryAfter(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(cfg.QueueTimeout.Seconds())))))
+}
+
+// acquireSlot waits up to cfg.QueueTimeout for a processing slot. It reports
+// false if none became free in time or ctx was done first.
+func acquireSlot(ctx context.Context) bool {
+	select {
+	case processingSlots <- struct{}{}:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case processingSlots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// releaseSlot frees a slot taken by acquireSlot
+func releaseSlot() {
+	<-processingSlots
+}
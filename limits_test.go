@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUploadConcurrencyLimit(t *testing.T) {
+	defer func(timeout time.Duration) { cfg.QueueTimeout = timeout }(cfg.QueueTimeout)
+	cfg.QueueTimeout = 50 * time.Millisecond
+
+	// Stand in for cap(processingSlots) uploads being cleaned
+	for range cap(processingSlots) {
+		processingSlots <- struct{}{}
+	}
+	held := cap(processingSlots)
+	defer func() {
+		for range held {
+			releaseSlot()
+		}
+	}()
+
+	rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery, "a.csv", []byte(statementCSV)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("overflow upload: status %d, want %d: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("overflow upload: Retry-After %q, want %q", got, "1")
+	}
+
+	// A queued upload goes ahead once a slot is freed within the timeout
+	cfg.QueueTimeout = 5 * time.Second
+	r := newUploadRequest(t, "/upload"+statementQuery, "b.csv", []byte(statementCSV))
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- serve(uploadHandler, r) }()
+	time.Sleep(20 * time.Millisecond)
+	releaseSlot()
+	held--
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Errorf("queued upload: status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
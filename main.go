@@ -1,211 +1,398 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
-	"encoding/csv"
-	"fmt"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"path"
 	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
 	"github.com/gorilla/handlers"
-	"github.com/xuri/excelize/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// CleanSpreadsheet function to process the uploaded file
-func CleanSpreadsheet(filePath string) (string, string, error) {
-	f, err := excelize.OpenFile(filePath)
-	if err != nil {
-		return "", "", err
-	}
-	defer f.Close()
-
-	var creditCSV, debitCSV strings.Builder
-	creditWriter := csv.NewWriter(&creditCSV)
-	defer creditWriter.Flush()
-	debitWriter := csv.NewWriter(&debitCSV)
-	defer debitWriter.Flush()
-
-	for _, sheet := range f.GetSheetList() {
-		// Remove merged cells
-		mergedCells, err := f.GetMergeCells(sheet)
-		if err != nil {
-			return "", "", err
-		}
-		for _, mc := range mergedCells {
-			err = f.UnmergeCell(sheet, mc.GetStartAxis(), mc.GetEndAxis())
-			if err != nil {
-				return "", "", err
-			}
+// defaultPreviewLimit is the number of transactions of each type /preview returns
+const defaultPreviewLimit = 20
+
+// outputFilename derives the download name from the uploaded filename,
+// e.g. statement_2024.xlsx becomes statement_2024.processed.zip. Directory
+// components and control characters are dropped.
+func outputFilename(uploaded, ext string) string {
+	name := path.Base(strings.ReplaceAll(uploaded, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
 		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "processed_files" + ext
+	}
+	return name + ".processed" + ext
+}
 
-		// Remove the first 25 rows
-		for i := 1; i <= 25; i++ {
-			err := f.RemoveRow(sheet, i)
-			if err != nil {
-				return "", "", err
-			}
-		}
+// inputFormat identifies the kind of spreadsheet uploaded
+type inputFormat int
+
+const (
+	formatUnknown inputFormat = iota
+	formatXLSX
+	formatEncryptedXLSX
+	formatCSV
+	// formatXLS is a compound file that is not an encrypted xlsx, which in
+	// practice is a legacy xls workbook
+	formatXLS
+)
 
-		// Remove the last 14 rows
-		rows, err := f.GetRows(sheet)
-		if err != nil {
-			return "", "", err
-		}
-		for i := len(rows) - 14; i < len(rows); i++ {
-			err := f.RemoveRow(sheet, i+1)
-			if err != nil {
-				return "", "", err
-			}
+// oleSignature starts the compound file container of password protected
+// workbooks and legacy xls workbooks
+var oleSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// detectFormat sniffs the leading bytes of an upload to decide whether it is
+// an xlsx or macro-enabled xlsm workbook (a zip archive, or a compound file
+// with an EncryptionInfo stream when encrypted), a legacy xls workbook (any
+// other compound file) or CSV text. Anything else is reported as
+// formatUnknown. The file is rewound before returning.
+func detectFormat(file io.ReadSeeker) (inputFormat, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return formatUnknown, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return formatUnknown, err
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return formatXLSX, nil
+	case bytes.HasPrefix(head, oleSignature):
+		encrypted, err := oleHasStream(file, oleEncryptionStream)
+		if _, seekErr := file.Seek(0, io.SeekStart); err == nil {
+			err = seekErr
 		}
-
-		// Re-read rows after removals
-		rows, err = f.GetRows(sheet)
 		if err != nil {
-			return "", "", err
+			return formatUnknown, err
 		}
-
-		if len(rows) == 0 {
-			fmt.Printf("No rows found in sheet %s.\n", sheet)
-			continue
+		if !encrypted {
+			return formatXLS, nil
 		}
+		return formatEncryptedXLSX, nil
+	case strings.HasPrefix(http.DetectContentType(head), "text/"):
+		return formatCSV, nil
+	}
+	return formatUnknown, nil
+}
 
-		for rowIndex, row := range rows {
-			// Skip header row or rows without sufficient columns
-			if rowIndex == 0 || len(row) < 39 {
-				continue
-			}
+// randomID returns n random bytes hex encoded
+func randomID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
 
-			amountStr := row[37]
-			amountStr = strings.Replace(amountStr, ",", "", -1)
+// requestLogger returns a logger tagged with the request's ID, taken from the
+// X-Request-ID header or generated, and echoes the ID in the response
+func requestLogger(w http.ResponseWriter, r *http.Request) *slog.Logger {
+	return slog.Default().With("request_id", requestID(w, r))
+}
 
-			// Handle empty or invalid amount strings
-			if amountStr == "" || amountStr == "Amount" {
-				continue
-			}
+// requestID returns the ID echoed in the response's X-Request-ID header,
+// taking it from the request or generating one the first time
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		return id
+	}
+	id := r.Header.Get("X-Request-ID")
+	if id == "" {
+		id = randomID(8)
+	}
+	w.Header().Set("X-Request-ID", id)
+	return id
+}
 
-			amount, err := strconv.ParseFloat(amountStr, 64)
-			if err != nil {
-				fmt.Println("Error parsing amount:", err)
-				continue
-			}
+// errorResponse is the body of error responses to clients that accept JSON
+type errorResponse struct {
+	Error     string `json:"error"`
+	RequestID string `json:"requestId"`
+}
 
-			formattedAmount := strconv.FormatFloat(amount, 'f', -1, 64)
-			newRow := []string{row[0], row[24], formattedAmount}
-
-			// Check if the amount is negative for credits
-			if strings.HasPrefix(amountStr, "-") {
-				// Convert the amount to positive
-				positiveAmount := strconv.FormatFloat(-amount, 'f', -1, 64)
-				newRow[2] = positiveAmount
-				err = creditWriter.Write(newRow)
-				if err != nil {
-					return "", "", err
-				}
-			} else {
-				err = debitWriter.Write(newRow)
-				if err != nil {
-					return "", "", err
-				}
-			}
-		}
+// writeError replies with message and status like http.Error, but as a JSON
+// errorResponse when the client accepts JSON
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	id := requestID(w, r)
+	if !accepts(r, "application/json") {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message, RequestID: id})
+}
 
-		creditWriter.Flush()
-		debitWriter.Flush()
+// previewHandler returns the first rows of the cleaned transactions as
+// JSON so the trim and column options can be checked before downloading
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := parseNonNegativeInt(r, "limit", defaultPreviewLimit)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	return creditCSV.String(), debitCSV.String(), nil
+	upload := cleanUpload(w, r)
+	if upload == nil {
+		return
+	}
+	writeJSON(w, newJSONResponse(upload.Result, limit))
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Only POST method is allowed", http.StatusMethodNotAllowed)
+	if r.URL.Query().Get("stream") == "true" {
+		streamUpload(w, r)
 		return
 	}
 
-	file, _, err := r.FormFile("file")
+	outOpts, err := parseOutputOptions(r)
 	if err != nil {
-		http.Error(w, "Unable to read file from form", http.StatusBadRequest)
+		writeError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	tmpFile, err := os.CreateTemp("", "uploaded-*.xlsx")
-	if err != nil {
-		http.Error(w, "Unable to create temporary file", http.StatusInternalServerError)
+	if !parseForm(w, r) {
 		return
 	}
-	defer os.Remove(tmpFile.Name())
-
-	if _, err := io.Copy(tmpFile, file); err != nil {
-		http.Error(w, "Unable to save uploaded file", http.StatusInternalServerError)
+	if len(formFiles(r)) > 1 {
+		batchUpload(w, r, outOpts)
 		return
 	}
 
-	creditCSV, debitCSV, err := CleanSpreadsheet(tmpFile.Name())
-	if err != nil {
-		http.Error(w, "Error processing file: "+err.Error(), http.StatusInternalServerError)
+	toS3 := r.URL.Query().Get("sink") == "s3"
+	if toS3 && cfg.S3Bucket == "" {
+		writeError(w, r, "The S3 sink is not configured", http.StatusBadRequest)
 		return
 	}
 
-	if creditCSV == "" && debitCSV == "" {
-		http.Error(w, "No data processed from the file", http.StatusInternalServerError)
+	if outOpts.Mode == outputNDJSON && !toS3 && !accepts(r, "application/json") {
+		streamNDJSONUpload(w, r, outOpts)
 		return
 	}
 
-	// Create a zip archive in memory
-	buf := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buf)
+	upload := cleanUpload(w, r)
+	if upload == nil {
+		return
+	}
+	result := upload.Result
 
-	// Add credits.csv to the zip archive
-	creditFile, err := zipWriter.Create("credits.csv")
+	if accepts(r, "application/json") {
+		if outOpts.Mode == outputMonthly {
+			writeJSON(w, monthlyResponse{summarizeByMonth(result.Transactions, upload.Options.CreditsIncreaseBalance), result.Summary})
+			return
+		}
+		writeJSON(w, newJSONResponse(result, -1))
+		return
+	}
+	files, err := outputFiles(upload, outOpts)
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		writeError(w, r, uploadErr.Message, uploadErr.Status)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Error creating zip file: "+err.Error(), http.StatusInternalServerError)
+		internalError(w, r, http.StatusInternalServerError, "Error writing output", err)
 		return
 	}
-	creditFile.Write([]byte(creditCSV))
 
-	// Add debits.csv to the zip archive
-	debitFile, err := zipWriter.Create("debits.csv")
-	if err != nil {
-		http.Error(w, "Error creating zip file: "+err.Error(), http.StatusInternalServerError)
+	if toS3 {
+		objects, err := uploadToS3(r.Context(), files)
+		if err != nil {
+			internalError(w, r, http.StatusBadGateway, "Error storing output", err)
+			return
+		}
+		writeJSON(w, map[string]any{"objects": objects, "summary": result.Summary})
 		return
 	}
-	debitFile.Write([]byte(debitCSV))
 
-	// Close the zip archive
-	if err := zipWriter.Close(); err != nil {
-		http.Error(w, "Error closing zip file: "+err.Error(), http.StatusInternalServerError)
+	// In ofx, qif, xlsx and gzip mode only the first file is returned
+	switch outOpts.Mode {
+	case outputOFX:
+		setSummaryHeaders(w, result.Summary)
+		writeFile(w, outputFilename(upload.Filename, ".ofx"), "application/x-ofx", files[0].Data)
+		return
+	case outputQIF:
+		setSummaryHeaders(w, result.Summary)
+		writeFile(w, outputFilename(upload.Filename, ".qif"), "application/qif", files[0].Data)
+		return
+	case outputXLSX:
+		setSummaryHeaders(w, result.Summary)
+		writeFile(w, outputFilename(upload.Filename, ".xlsx"), xlsxContentType, files[0].Data)
+		return
+	case outputGzip:
+		setSummaryHeaders(w, result.Summary)
+		if err := writeGzip(w, outputFilename(upload.Filename, outOpts.ext()+".gz"), files[0].Data); err != nil {
+			slog.Error("writing gzip response", "error", err)
+		}
 		return
 	}
 
-	// Set response headers
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", "attachment; filename=processed_files.zip")
+	if accepts(r, "multipart/mixed") {
+		setSummaryHeaders(w, result.Summary)
+		if err := writeMultipart(w, files); err != nil {
+			// Headers are already sent, so the client sees a truncated body
+			slog.Error("writing multipart response", "error", err)
+		}
+		return
+	}
 
-	// Write the zip archive to the response
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		http.Error(w, "Error writing response: "+err.Error(), http.StatusInternalServerError)
+	archive, err := zipFiles(files)
+	if err != nil {
+		internalError(w, r, http.StatusInternalServerError, "Error creating zip file", err)
 		return
 	}
+
+	setSummaryHeaders(w, result.Summary)
+	writeZip(w, outputFilename(upload.Filename, ".zip"), archive)
+}
+
+// healthHandler reports that the server is up without doing any work
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// newServer returns a server for handler on addr with the configured timeouts
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
 }
 
 func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	loadConfig()
+	processingSlots = make(chan struct{}, cfg.MaxConcurrent)
+	jobSlots = make(chan struct{}, cfg.MaxJobs)
+	if cfg.TempFiles {
+		sweepTempFiles()
+	}
+	if cfg.In != "" {
+		os.Exit(runCLI())
+	}
+
 	// Create a new router
 	router := http.NewServeMux()
 
 	// Handle the upload route
-	router.HandleFunc("/upload", uploadHandler)
+	router.HandleFunc("/upload", idempotent(uploadHandler))
+	router.HandleFunc("/preview", previewHandler)
+	router.HandleFunc("POST /validate", validateHandler)
+	router.HandleFunc("POST /jobs", idempotent(createJobHandler))
+	router.HandleFunc("GET /jobs/{id}", jobStatusHandler)
+	router.HandleFunc("GET /jobs/{id}/result", jobResultHandler)
+	router.HandleFunc("GET /jobs/{id}/download", jobDownloadHandler)
 
 	// Add CORS middleware
 	corsHandler := handlers.CORS(
-		handlers.AllowedOrigins([]string{"*"}),    // Allow requests from any origin
-		handlers.AllowedMethods([]string{"POST"}), // Allow only POST requests
+		handlers.AllowedOrigins(cfg.CORSOrigins),
+		handlers.AllowedMethods(cfg.CORSMethods),
+		handlers.AllowedHeaders([]string{"Authorization", "X-API-Key", "Idempotency-Key", "X-Request-ID"}),
 	)
 
-	// Wrap the router with the CORS middleware
-	http.ListenAndServe(":6666", corsHandler(router))
+	// Health probes, version and metrics bypass the CORS middleware
+	root := http.NewServeMux()
+	root.HandleFunc("GET /healthz", healthHandler)
+	root.HandleFunc("GET /version", versionHandler)
+	root.Handle("GET /metrics", promhttp.Handler())
+	root.Handle("/", corsHandler(rateLimit(requireAPIKey(compressJSON(router)))))
+
+	server := newServer(cfg.Addr, accessLog(recoverPanics(root)))
+
+	// Stop accepting connections on SIGINT/SIGTERM and let active requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go jobs.expire(ctx, time.Minute)
+	if cfg.ResultsDir != "" {
+		go sweepResults(ctx, time.Minute)
+	}
+	if cfg.RateLimit > 0 {
+		go limiter.sweep(ctx, time.Minute)
+	}
+	// Optionally redirect plain HTTP to the TLS listener
+	var redirectServer *http.Server
+	if cfg.HTTPRedirectAddr != "" {
+		redirectServer = newServer(cfg.HTTPRedirectAddr, http.HandlerFunc(httpsRedirect))
+		go func() {
+			slog.Info("redirecting to https", "addr", cfg.HTTPRedirectAddr)
+			if err := redirectServer.ListenAndServe(); err != http.ErrServerClosed {
+				slog.Error("redirect server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Optionally serve profiles on a separate admin listener
+	var pprofServer *http.Server
+	if cfg.PprofAddr != "" {
+		pprofServer = newServer(cfg.PprofAddr, pprofHandler())
+		// Profiles may be longer than the upload write timeout
+		pprofServer.WriteTimeout = 0
+		go func() {
+			slog.Info("serving pprof", "addr", cfg.PprofAddr)
+			if err := pprofServer.ListenAndServe(); err != http.ErrServerClosed {
+				slog.Error("pprof server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if redirectServer != nil {
+			redirectServer.Shutdown(shutdownCtx)
+		}
+		if pprofServer != nil {
+			pprofServer.Close()
+		}
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("shutdown failed", "error", err)
+		}
+	}()
+
+	var err error
+	if cfg.TLSCert != "" {
+		slog.Info("listening", "addr", cfg.Addr, "tls", true)
+		err = server.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	} else {
+		slog.Info("listening", "addr", cfg.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+	<-shutdownDone
 }
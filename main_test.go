@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	processingSlots = make(chan struct{}, cfg.MaxConcurrent)
+	jobSlots = make(chan struct{}, cfg.MaxJobs)
+	os.Exit(m.Run())
+}
+
+// newUploadRequest returns a POST request to target carrying content as the
+// upload form file, named filename
+func newUploadRequest(t *testing.T, target, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile(cfg.FormField, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+// serve runs r through handler and returns the recorded response
+func serve(handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+// statementCSV is a small statement in the header mapped layout
+const statementCSV = "Date,Description,Amount\n2024-01-02,Coffee,-3.50\n2024-01-03,Salary,1000\n"
+
+// statementQuery cleans statementCSV
+const statementQuery = "?skipTop=0&skipBottom=0&mapHeaders=true"
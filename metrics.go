@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	uploadsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cleaner_uploads_total",
+		Help: "Uploads received.",
+	})
+	uploadFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cleaner_upload_failures_total",
+		Help: "Uploads that did not produce a result.",
+	})
+	rowsProcessedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cleaner_rows_processed_total",
+		Help: "Rows classified as credits or debits.",
+	})
+	rowsSkippedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cleaner_rows_skipped_total",
+		Help: "Data rows dropped during cleaning.",
+	})
+	processingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cleaner_processing_duration_seconds",
+		Help:    "Time spent cleaning an upload.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+)
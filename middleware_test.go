@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	handler := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("corrupt workbook")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/upload", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Internal server error") || strings.Contains(body, "corrupt workbook") {
+		t.Errorf("body %q should be the generic message only", body)
+	}
+}
+
+func TestAccessLogAbortedRequest(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	handler := accessLog(recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	})))
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("panic %v, want http.ErrAbortHandler to reach net/http", v)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", nil))
+	}()
+
+	var line struct {
+		Status  int   `json:"status"`
+		Bytes   int64 `json:"bytes"`
+		Aborted bool  `json:"aborted"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("access log %q: %v", logs.String(), err)
+	}
+	if line.Status != http.StatusOK || line.Bytes != int64(len("partial")) || !line.Aborted {
+		t.Errorf("logged status %d, bytes %d, aborted %v; want 200, 7, true", line.Status, line.Bytes, line.Aborted)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	defer func(key string) { cfg.APIKey = key }(cfg.APIKey)
+	cfg.APIKey = "secret"
+	handler := requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{name: "missing key", want: http.StatusUnauthorized},
+		{name: "wrong bearer token", header: "Authorization", value: "Bearer guess", want: http.StatusUnauthorized},
+		{name: "wrong header key", header: "X-API-Key", value: "secre", want: http.StatusUnauthorized},
+		{name: "key without bearer scheme", header: "Authorization", value: "secret", want: http.StatusUnauthorized},
+		{name: "correct bearer token", header: "Authorization", value: "Bearer secret", want: http.StatusOK},
+		{name: "correct header key", header: "X-API-Key", value: "secret", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/upload", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("WWW-Authenticate %q, want Bearer", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+
+	cfg.APIKey = ""
+	rec := httptest.NewRecorder()
+	requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, httptest.NewRequest("POST", "/upload", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("without a configured key: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/csv"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// undatedMonth is the month of transactions whose date was not recognised
+const undatedMonth = "undated"
+
+// monthTotals totals the credits and debits of one calendar month. Net is
+// the change they make to the running balance: debits add to it and credits
+// subtract from it, or the reverse with balanceAdds=credit.
+type monthTotals struct {
+	Month       string  `json:"month"`
+	CreditTotal float64 `json:"creditTotal"`
+	DebitTotal  float64 `json:"debitTotal"`
+	Net         float64 `json:"net"`
+}
+
+// monthlyResponse is the JSON body of output=monthly
+type monthlyResponse struct {
+	Months  []monthTotals   `json:"months"`
+	Summary cleaner.Summary `json:"summary"`
+}
+
+// summarizeByMonth totals transactions per year and month of their
+// recognised date, in calendar order. Undated transactions are totalled
+// last under undatedMonth.
+func summarizeByMonth(transactions []cleaner.Transaction, creditsIncrease bool) []monthTotals {
+	index := make(map[string]int)
+	months := []monthTotals{}
+	for _, t := range transactions {
+		month := undatedMonth
+		if !t.ParsedDate.IsZero() {
+			month = t.ParsedDate.Format("2006-01")
+		}
+		i, ok := index[month]
+		if !ok {
+			i = len(months)
+			index[month] = i
+			months = append(months, monthTotals{Month: month})
+		}
+		switch t.Type {
+		case cleaner.Credit:
+			months[i].CreditTotal += t.Amount
+		case cleaner.Debit:
+			months[i].DebitTotal += t.Amount
+		}
+	}
+	for i := range months {
+		m := &months[i]
+		m.Net = m.DebitTotal - m.CreditTotal
+		if creditsIncrease {
+			m.Net = -m.Net
+		}
+	}
+	// "undated" sorts after every YYYY-MM month
+	slices.SortFunc(months, func(a, b monthTotals) int { return strings.Compare(a.Month, b.Month) })
+	return months
+}
+
+// writeMonthlyCSV serializes the monthly totals with a header line
+func writeMonthlyCSV(months []monthTotals, opts outputOptions) (string, error) {
+	var monthlyCSV strings.Builder
+	writer := csv.NewWriter(&monthlyCSV)
+	writer.Comma = opts.Delimiter
+
+	writer.Write([]string{"month", "credit_total", "debit_total", "net"})
+	for _, m := range months {
+		writer.Write([]string{m.Month, formatAmount(m.CreditTotal, opts), formatAmount(m.DebitTotal, opts), formatAmount(m.Net, opts)})
+	}
+	writer.Flush()
+	return monthlyCSV.String(), writer.Error()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// monthlyStatementCSV spans several months. 2024 is a leap year, so its 29
+// February is dated, while 29 February 2023 does not exist.
+const monthlyStatementCSV = "Date,Description,Amount\n" +
+	"2024-01-31,Rent,-500\n" +
+	"2024-02-01,Salary,2000\n" +
+	"2024-02-29,Groceries,-80.25\n" +
+	"2024-02-29,Refund,10\n" +
+	"2024-03-01,Coffee,-3.50\n" +
+	"2023-02-28,Old fee,-1\n" +
+	"2023-02-29,Typo,-2\n"
+
+func TestMonthlyOutput(t *testing.T) {
+	want := []monthTotals{
+		{Month: "2023-02", CreditTotal: 1, Net: -1},
+		{Month: "2024-01", CreditTotal: 500, Net: -500},
+		{Month: "2024-02", CreditTotal: 80.25, DebitTotal: 2010, Net: 1929.75},
+		{Month: "2024-03", CreditTotal: 3.5, Net: -3.5},
+		{Month: undatedMonth, CreditTotal: 2, Net: -2},
+	}
+
+	r := newUploadRequest(t, "/upload"+statementQuery+"&output=monthly", "a.csv", []byte(monthlyStatementCSV))
+	r.Header.Set("Accept", "application/json")
+	rec := serve(uploadHandler, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("json: status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var response monthlyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.Months, want) {
+		t.Errorf("json months = %+v, want %+v", response.Months, want)
+	}
+
+	rec = serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery+"&output=monthly", "a.csv", []byte(monthlyStatementCSV)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("csv: status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	wantCSV := "month,credit_total,debit_total,net\n" +
+		"2023-02,1,0,-1\n" +
+		"2024-01,500,0,-500\n" +
+		"2024-02,80.25,2010,1929.75\n" +
+		"2024-03,3.5,0,-3.5\n" +
+		"undated,2,0,-2\n"
+	if got := unzipResponse(t, rec.Body.Bytes())["monthly.csv"]; got != wantCSV {
+		t.Errorf("monthly.csv = %q, want %q", got, wantCSV)
+	}
+}
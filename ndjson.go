@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// ndjsonContentType is the media type of newline delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonRecord is one line of NDJSON output. Amount is a number formatted
+// like the CSV output, so round and outputPrecision apply.
+type ndjsonRecord struct {
+	Date        string       `json:"date"`
+	Description string       `json:"description"`
+	Amount      json.Number  `json:"amount"`
+	Type        cleaner.Type `json:"type"`
+}
+
+// newNDJSONRecord returns the NDJSON line of t
+func newNDJSONRecord(t cleaner.Transaction, opts outputOptions) ndjsonRecord {
+	return ndjsonRecord{t.Date, t.Description, json.Number(formatAmount(t.Amount, opts)), t.Type}
+}
+
+// writeNDJSON writes one JSON object per transaction and line to w,
+// encoding each as it goes rather than building the document in memory
+func writeNDJSON(w io.Writer, transactions []cleaner.Transaction, opts outputOptions) error {
+	enc := json.NewEncoder(w)
+	for _, t := range transactions {
+		if err := enc.Encode(newNDJSONRecord(t, opts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setNDJSONHeaders sets the headers of an NDJSON download named filename
+func setNDJSONHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// streamNDJSON writes the transactions as an NDJSON download named filename
+func streamNDJSON(w http.ResponseWriter, filename string, upload *cleanedUpload, opts outputOptions) error {
+	setSummaryHeaders(w, upload.Summary)
+	setNDJSONHeaders(w, filename)
+	return writeNDJSON(w, upload.Transactions, opts)
+}
+
+// ndjsonStream writes transactions to an NDJSON download as the cleaner
+// classifies them. The response starts with the first line, so an upload
+// that fails before then still gets an error status. The summary is only
+// known once cleaning ends and is sent in trailers.
+type ndjsonStream struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	filename string
+	opts     outputOptions
+	enc      *json.Encoder
+}
+
+// start sends the response headers, unless they were already sent
+func (s *ndjsonStream) start() {
+	if s.enc != nil {
+		return
+	}
+	setNDJSONHeaders(s.w, s.filename)
+	s.w.Header().Set("Trailer", strings.Join(summaryHeaders, ", ")+", X-Unresolved-Headers")
+	s.w.WriteHeader(http.StatusOK)
+	s.enc = json.NewEncoder(s.w)
+}
+
+// write is the cleaner.Options.OnTransaction of the stream. Each line is
+// flushed so the client receives it without waiting for the next ones.
+func (s *ndjsonStream) write(t cleaner.Transaction) error {
+	s.start()
+	if err := s.enc.Encode(newNDJSONRecord(t, s.opts)); err != nil {
+		return err
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// streamNDJSONUpload cleans the upload in output=ndjson mode, writing each
+// transaction to the response as it is classified. Options that need every
+// transaction at once, such as sorting, fall back to writing them all once
+// cleaning ends.
+func streamNDJSONUpload(w http.ResponseWriter, r *http.Request, opts outputOptions) {
+	pending := readUpload(w, r)
+	if pending == nil {
+		return
+	}
+	if !pending.Options.Streamable() {
+		upload := processUpload(w, r, pending)
+		if upload == nil {
+			return
+		}
+		if err := streamNDJSON(w, outputFilename(upload.Filename, ".ndjson"), upload, opts); err != nil {
+			slog.Error("writing ndjson response", "error", err)
+		}
+		return
+	}
+	defer pending.Close()
+
+	stream := &ndjsonStream{w: w, rc: http.NewResponseController(w), filename: outputFilename(pending.Filename, ".ndjson"), opts: opts}
+	pending.Options.OnTransaction = stream.write
+	upload, err := pending.process(r.Context())
+	switch {
+	case err != nil && stream.enc == nil:
+		writeUploadError(w, r, err)
+		return
+	case err != nil:
+		// The status is already sent, so cut the response short rather than
+		// let the client take the lines so far for the whole statement
+		slog.Error("streaming ndjson response", "request_id", requestID(w, r), "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	// An upload allowed to be empty may not have started the response
+	stream.start()
+	setUnresolvedHeader(w, upload)
+	setSummaryHeaders(w, upload.Summary)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNDJSONUpload(t *testing.T) {
+	csv := "Date,Description,Amount\n2024-01-03,Salary,1000\n2024-01-02,Coffee,-3.50\n"
+	tests := []struct {
+		name     string
+		query    string
+		body     string
+		streamed bool
+	}{
+		{
+			name:     "streamed in source order",
+			body:     `{"date":"2024-01-03","description":"Salary","amount":1000,"type":"debit"}` + "\n" + `{"date":"2024-01-02","description":"Coffee","amount":3.5,"type":"credit"}` + "\n",
+			streamed: true,
+		},
+		{
+			name:  "sorted after cleaning",
+			query: "&sort=date",
+			body:  `{"date":"2024-01-02","description":"Coffee","amount":3.5,"type":"credit"}` + "\n" + `{"date":"2024-01-03","description":"Salary","amount":1000,"type":"debit"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery+"&output=ndjson"+tt.query, "a.csv", []byte(csv)))
+			// The recorder keeps the headers as they were when the response
+			// started, and the trailers set after the body
+			response := rec.Result()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", response.StatusCode, http.StatusOK, rec.Body)
+			}
+			if got := response.Header.Get("Content-Type"); got != ndjsonContentType {
+				t.Errorf("Content-Type %q, want %q", got, ndjsonContentType)
+			}
+			if got := rec.Body.String(); got != tt.body {
+				t.Errorf("body\n%s\nwant\n%s", got, tt.body)
+			}
+			summary := response.Header
+			if tt.streamed {
+				if got := response.Header.Get("X-Credit-Count"); got != "" {
+					t.Errorf("summary header X-Credit-Count %q sent before the body", got)
+				}
+				summary = response.Trailer
+			}
+			if summary.Get("X-Credit-Count") != "1" || summary.Get("X-Debit-Total") != "1000" {
+				t.Errorf("summary credit count %q and debit total %q, want 1 and 1000", summary.Get("X-Credit-Count"), summary.Get("X-Debit-Total"))
+			}
+		})
+	}
+
+	rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery+"&output=ndjson", "a.csv", []byte("Date,Description,Amount\n")))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("upload without data: status %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+// gatedWriter holds back every write after the first until gate is closed
+type gatedWriter struct {
+	http.ResponseWriter
+	writes int
+	gate   chan struct{}
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	if g.writes++; g.writes > 1 {
+		<-g.gate
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+func (g *gatedWriter) Unwrap() http.ResponseWriter { return g.ResponseWriter }
+
+func TestNDJSONUploadFlushesEachLine(t *testing.T) {
+	gate := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploadHandler(&gatedWriter{ResponseWriter: w, gate: gate}, r)
+	}))
+	defer srv.Close()
+
+	r := newUploadRequest(t, srv.URL+"/upload"+statementQuery+"&output=ndjson", "a.csv", []byte(statementCSV))
+	r.RequestURI = ""
+
+	// The second line is not written until the first has been read, so
+	// the response only starts if the first line was flushed on its own
+	type result struct {
+		body  *bufio.Reader
+		first string
+		err   error
+	}
+	started := make(chan result, 1)
+	go func() {
+		response, err := http.DefaultClient.Do(r)
+		if err != nil {
+			started <- result{err: err}
+			return
+		}
+		body := bufio.NewReader(response.Body)
+		line, err := body.ReadString('\n')
+		started <- result{body, line, err}
+	}()
+	var got result
+	select {
+	case got = <-started:
+		close(gate)
+	case <-time.After(5 * time.Second):
+		close(gate)
+		t.Fatal("first line not received before the upload finished")
+	}
+	if got.err != nil {
+		t.Fatal(got.err)
+	}
+	if want := `{"date":"2024-01-02","description":"Coffee","amount":3.5,"type":"credit"}` + "\n"; got.first != want {
+		t.Fatalf("first line %q, want %q", got.first, want)
+	}
+	if rest, _ := got.body.ReadString('\n'); rest != `{"date":"2024-01-03","description":"Salary","amount":1000,"type":"debit"}`+"\n" {
+		t.Errorf("second line %q", rest)
+	}
+}
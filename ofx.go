@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// ofxDate is the OFX layout for dates without a time
+const ofxDate = "20060102"
+
+// ofxNameLength is the longest NAME allowed by OFX 1.0.2
+const ofxNameLength = 32
+
+// ofxEscaper escapes the characters that are special in OFX SGML
+var ofxEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// ofxText escapes s for an OFX element, truncated to at most n runes when n
+// is positive
+func ofxText(s string, n int) string {
+	if runes := []rune(s); n > 0 && len(runes) > n {
+		s = string(runes[:n])
+	}
+	return ofxEscaper.Replace(s)
+}
+
+// ofxFITID derives the FITID of a transaction from its date, type, amount
+// and description, so overlapping statements exported separately give a
+// transaction the same ID and importers drop only true duplicates. seen
+// counts the transactions identified so far by key, telling apart identical
+// transactions on one statement.
+func ofxFITID(t cleaner.Transaction, amount string, seen map[string]int) string {
+	key := strings.Join([]string{t.ParsedDate.Format(ofxDate), string(t.Type), amount, t.Description}, "\x00")
+	seen[key]++
+	sum := sha256.Sum256([]byte(key + "\x00" + strconv.Itoa(seen[key])))
+	return hex.EncodeToString(sum[:16])
+}
+
+// writeOFX serializes the transactions as an OFX 1.0.2 bank statement for
+// the account in opts. Credits are written with positive amounts and debits
+// with negative ones. The ledger balance is only written when there is a
+// closing balance. Every transaction needs a recognised date; without one an
+// *uploadError is returned.
+func writeOFX(upload *cleanedUpload, opts outputOptions) (string, error) {
+	var start, end time.Time
+	for _, t := range upload.Transactions {
+		if t.ParsedDate.IsZero() {
+			return "", &uploadError{http.StatusUnprocessableEntity, fmt.Sprintf("Transaction %q has an unrecognised date %q", t.Description, t.Date)}
+		}
+		if start.IsZero() || t.ParsedDate.Before(start) {
+			start = t.ParsedDate
+		}
+		if t.ParsedDate.After(end) {
+			end = t.ParsedDate
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("OFXHEADER:100\nDATA:OFXSGML\nVERSION:102\nSECURITY:NONE\nENCODING:UNICODE\nCHARSET:NONE\nCOMPRESSION:NONE\nOLDFILEUID:NONE\nNEWFILEUID:NONE\n\n")
+	b.WriteString("<OFX>\n<SIGNONMSGSRSV1>\n<SONRS>\n<STATUS>\n<CODE>0\n<SEVERITY>INFO\n</STATUS>\n")
+	fmt.Fprintf(&b, "<DTSERVER>%s\n<LANGUAGE>ENG\n</SONRS>\n</SIGNONMSGSRSV1>\n", time.Now().UTC().Format("20060102150405"))
+	b.WriteString("<BANKMSGSRSV1>\n<STMTTRNRS>\n<TRNUID>0\n<STATUS>\n<CODE>0\n<SEVERITY>INFO\n</STATUS>\n<STMTRS>\n")
+	fmt.Fprintf(&b, "<CURDEF>%s\n<BANKACCTFROM>\n<BANKID>%s\n<ACCTID>%s\n<ACCTTYPE>CHECKING\n</BANKACCTFROM>\n",
+		opts.Currency, ofxText(opts.BankID, 0), ofxText(opts.Account, 0))
+	fmt.Fprintf(&b, "<BANKTRANLIST>\n<DTSTART>%s\n<DTEND>%s\n", start.Format(ofxDate), end.Format(ofxDate))
+	seen := make(map[string]int)
+	for _, t := range upload.Transactions {
+		amount, trnType := -t.Amount, "DEBIT"
+		switch t.Type {
+		case cleaner.Credit:
+			amount, trnType = t.Amount, "CREDIT"
+		case cleaner.Zero:
+			amount, trnType = 0, "OTHER"
+		}
+		formatted := formatAmount(amount, opts)
+		fmt.Fprintf(&b, "<STMTTRN>\n<TRNTYPE>%s\n<DTPOSTED>%s\n<TRNAMT>%s\n<FITID>%s\n<NAME>%s\n</STMTTRN>\n",
+			trnType, t.ParsedDate.Format(ofxDate), formatted, ofxFITID(t, formatted, seen), ofxText(t.Description, ofxNameLength))
+	}
+	b.WriteString("</BANKTRANLIST>\n")
+
+	if balance := upload.Summary.ClosingBalance; balance != nil {
+		fmt.Fprintf(&b, "<LEDGERBAL>\n<BALAMT>%s\n<DTASOF>%s\n</LEDGERBAL>\n", strconv.FormatFloat(*balance, 'f', -1, 64), end.Format(ofxDate))
+	}
+	b.WriteString("</STMTRS>\n</STMTTRNRS>\n</BANKMSGSRSV1>\n</OFX>\n")
+	return b.String(), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// ofxTransaction returns a transaction dated day of January 2024
+func ofxTransaction(day int, description string, amount float64, typ cleaner.Type) cleaner.Transaction {
+	date := time.Date(2024, time.January, day, 0, 0, 0, 0, time.UTC)
+	return cleaner.Transaction{Date: date.Format("2006-01-02"), ParsedDate: date, Description: description, Amount: amount, Type: typ}
+}
+
+var fitidPattern = regexp.MustCompile(`<FITID>(.*)\n`)
+
+// ofxFITIDs writes transactions as OFX and returns their FITIDs in order
+func ofxFITIDs(t *testing.T, upload *cleanedUpload) []string {
+	t.Helper()
+	ofx, err := writeOFX(upload, outputOptions{Round: -1, Precision: -1, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, match := range fitidPattern.FindAllStringSubmatch(ofx, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+func TestOFXFITID(t *testing.T) {
+	coffee := ofxTransaction(2, "Coffee", 3.5, cleaner.Credit)
+	salary := ofxTransaction(3, "Salary", 1000, cleaner.Debit)
+	rent := ofxTransaction(1, "Rent", 500, cleaner.Credit)
+
+	first := ofxFITIDs(t, &cleanedUpload{Result: &cleaner.Result{Transactions: []cleaner.Transaction{coffee, salary}}})
+	// A later export of an overlapping period starts with another row
+	second := ofxFITIDs(t, &cleanedUpload{Result: &cleaner.Result{Transactions: []cleaner.Transaction{rent, coffee, salary}}})
+	if len(first) != 2 || len(second) != 3 {
+		t.Fatalf("FITIDs %q and %q, want 2 and 3", first, second)
+	}
+	if !slices.Equal(first, second[1:]) {
+		t.Errorf("FITIDs changed between exports: %q and %q", first, second[1:])
+	}
+
+	// Two identical coffees on one day are both kept, and keep their IDs
+	twice := ofxFITIDs(t, &cleanedUpload{Result: &cleaner.Result{Transactions: []cleaner.Transaction{coffee, coffee}}})
+	if twice[0] == twice[1] {
+		t.Errorf("identical transactions share FITID %q", twice[0])
+	}
+	if twice[0] != first[0] {
+		t.Errorf("first coffee FITID %q, want %q", twice[0], first[0])
+	}
+}
+
+func TestOFXLedgerBalance(t *testing.T) {
+	transactions := []cleaner.Transaction{ofxTransaction(2, "Coffee", 3.5, cleaner.Credit)}
+	opts := outputOptions{Round: -1, Precision: -1, Currency: "USD"}
+
+	ofx, err := writeOFX(&cleanedUpload{Result: &cleaner.Result{Transactions: transactions}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(ofx, "<LEDGERBAL>") {
+		t.Error("ledger balance written without a closing balance")
+	}
+
+	closing := 96.5
+	ofx, err = writeOFX(&cleanedUpload{Result: &cleaner.Result{Transactions: transactions, Summary: cleaner.Summary{ClosingBalance: &closing}}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ofx, "<LEDGERBAL>\n<BALAMT>96.5\n<DTASOF>20240102\n</LEDGERBAL>\n") {
+		t.Errorf("no ledger balance of 96.5 in\n%s", ofx)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+)
+
+// Compound file layout, from [MS-CFB]. Sector numbers at or above
+// oleMaxSector mark free sectors and the ends of chains.
+const (
+	oleHeaderSize     = 512
+	oleDirEntrySize   = 128
+	oleMaxSector      = 0xFFFFFFFA
+	oleHeaderFATSlots = 109
+
+	// oleMaxChain bounds the directory and DIFAT chains followed, so a
+	// corrupt file with a cycle in it cannot keep detection going
+	oleMaxChain = 1024
+)
+
+// oleEncryptionStream names the stream holding the encryption parameters of
+// a password protected xlsx. Legacy xls workbooks have none.
+const oleEncryptionStream = "EncryptionInfo"
+
+// oleHasStream reports whether the compound file in file has a directory
+// entry named name. Only the directory and the FAT sectors needed to follow
+// its chain are read.
+func oleHasStream(file io.ReadSeeker, name string) (found bool, err error) {
+	defer func() {
+		// A truncated file has no readable entry
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			found, err = false, nil
+		}
+	}()
+
+	header := make([]byte, oleHeaderSize)
+	if err := readAt(file, header, 0); err != nil {
+		return false, err
+	}
+	shift := binary.LittleEndian.Uint16(header[0x1E:])
+	if shift != 9 && shift != 12 {
+		return false, nil
+	}
+	sectorSize := int64(1) << shift
+	sector := func(n uint32, buf []byte) error {
+		return readAt(file, buf, (int64(n)+1)*sectorSize)
+	}
+
+	// The header lists the first FAT sectors and the DIFAT sectors chained
+	// from it the rest, each ending with the number of the next
+	perSector := uint32(sectorSize / 4)
+	var fats []uint32
+	for i := range oleHeaderFATSlots {
+		fats = append(fats, binary.LittleEndian.Uint32(header[0x4C+4*i:]))
+	}
+	difat := make([]byte, sectorSize)
+	n := binary.LittleEndian.Uint32(header[0x44:])
+	for range oleMaxChain {
+		if n >= oleMaxSector {
+			break
+		}
+		if err := sector(n, difat); err != nil {
+			return false, err
+		}
+		for i := range perSector - 1 {
+			fats = append(fats, binary.LittleEndian.Uint32(difat[4*i:]))
+		}
+		n = binary.LittleEndian.Uint32(difat[4*(perSector-1):])
+	}
+
+	fatSector := make([]byte, sectorSize)
+	loadedFAT := uint32(oleMaxSector)
+	nextSector := func(n uint32) (uint32, error) {
+		slot := n / perSector
+		if slot >= uint32(len(fats)) || fats[slot] >= oleMaxSector {
+			return oleMaxSector, nil
+		}
+		fat := fats[slot]
+		if fat != loadedFAT {
+			if err := sector(fat, fatSector); err != nil {
+				return 0, err
+			}
+			loadedFAT = fat
+		}
+		return binary.LittleEndian.Uint32(fatSector[4*(n%perSector):]), nil
+	}
+
+	dir := make([]byte, sectorSize)
+	n = binary.LittleEndian.Uint32(header[0x30:])
+	for range oleMaxChain {
+		if n >= oleMaxSector {
+			break
+		}
+		if err := sector(n, dir); err != nil {
+			return false, err
+		}
+		for entry := dir; len(entry) >= oleDirEntrySize; entry = entry[oleDirEntrySize:] {
+			if oleEntryName(entry) == name {
+				return true, nil
+			}
+		}
+		if n, err = nextSector(n); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// oleEntryName decodes the UTF-16 name of a directory entry
+func oleEntryName(entry []byte) string {
+	size := int(binary.LittleEndian.Uint16(entry[0x40:]))
+	if size < 2 || size > 64 {
+		return ""
+	}
+	units := make([]uint16, size/2-1)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(entry[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// readAt fills buf from offset in file
+func readAt(file io.ReadSeeker, buf []byte, offset int64) error {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(file, buf)
+	return err
+}
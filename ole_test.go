@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/gin-gonic/gin/internal/testworkbook"
+	"github.com/xuri/excelize/v2"
+)
+
+// compoundFile builds a compound file with 512 byte sectors whose directory
+// holds a root entry and the named streams, four entries to a sector. Sector
+// 0 is the FAT and the directory chain follows it.
+func compoundFile(names ...string) []byte {
+	const (
+		freeSector = 0xFFFFFFFF
+		endOfChain = 0xFFFFFFFE
+		fatSector  = 0xFFFFFFFD
+	)
+	entries := append([]string{"Root Entry"}, names...)
+	dirSectors := (len(entries) + 3) / 4
+	file := make([]byte, 512*(2+dirSectors))
+	le := binary.LittleEndian
+
+	copy(file, oleSignature)
+	le.PutUint16(file[0x1A:], 3)
+	le.PutUint16(file[0x1C:], 0xFFFE)
+	le.PutUint16(file[0x1E:], 9)
+	le.PutUint16(file[0x20:], 6)
+	le.PutUint32(file[0x2C:], 1)
+	le.PutUint32(file[0x30:], 1)
+	le.PutUint32(file[0x38:], 4096)
+	le.PutUint32(file[0x3C:], endOfChain)
+	le.PutUint32(file[0x44:], endOfChain)
+	for i := range oleHeaderFATSlots {
+		le.PutUint32(file[0x4C+4*i:], freeSector)
+	}
+	le.PutUint32(file[0x4C:], 0)
+
+	fat := file[512:1024]
+	for i := 0; i < len(fat); i += 4 {
+		le.PutUint32(fat[i:], freeSector)
+	}
+	le.PutUint32(fat, fatSector)
+	for s := 1; s <= dirSectors; s++ {
+		next := uint32(s + 1)
+		if s == dirSectors {
+			next = endOfChain
+		}
+		le.PutUint32(fat[4*s:], next)
+	}
+
+	for i, name := range entries {
+		entry := file[1024+oleDirEntrySize*i:]
+		units := utf16.Encode([]rune(name))
+		for j, u := range units {
+			le.PutUint16(entry[2*j:], u)
+		}
+		le.PutUint16(entry[0x40:], uint16(2*len(units)+2))
+		entry[0x42] = 2
+		if i == 0 {
+			entry[0x42] = 5
+		}
+	}
+	return file
+}
+
+func TestDetectFormat(t *testing.T) {
+	f, err := testworkbook.New([][]string{{"Date", "Description", "Amount"}, {"2024-01-02", "Coffee", "-3.50"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	plain, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	if err := f.Write(&encrypted, excelize.Options{Password: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		want    inputFormat
+	}{
+		{name: "xlsx", content: plain.Bytes(), want: formatXLSX},
+		{name: "encrypted xlsx", content: encrypted.Bytes(), want: formatEncryptedXLSX},
+		{name: "csv", content: []byte(statementCSV), want: formatCSV},
+		{name: "encryption streams", content: compoundFile("\x06DataSpaces", "EncryptionInfo", "EncryptedPackage"), want: formatEncryptedXLSX},
+		{name: "legacy xls", content: compoundFile("Workbook", "\x05SummaryInformation", "\x05DocumentSummaryInformation"), want: formatXLS},
+		{name: "encryption info in a later directory sector", content: compoundFile("a", "b", "c", "d", "e", "EncryptionInfo"), want: formatEncryptedXLSX},
+		{name: "truncated compound file", content: compoundFile("EncryptionInfo")[:600], want: formatXLS},
+		{name: "binary", content: []byte{0, 1, 2, 3}, want: formatUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := bytes.NewReader(tt.content)
+			got, err := detectFormat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("format %d, want %d", got, tt.want)
+			}
+			if offset, _ := file.Seek(0, io.SeekCurrent); offset != 0 {
+				t.Errorf("file left at offset %d, want it rewound", offset)
+			}
+		})
+	}
+}
+
+func TestOLEHasStreamDIFAT(t *testing.T) {
+	// The directory starts in sector 13952 and continues in the next one.
+	// The FAT entry linking them lies in the 110th FAT sector, beyond those
+	// the header lists, which the DIFAT in sector 0 names as sector 1.
+	const far = oleHeaderFATSlots * 128
+	le := binary.LittleEndian
+	file := make([]byte, 512*(far+3))
+	sector := func(n int) []byte { return file[512*(n+1):] }
+
+	copy(file, oleSignature)
+	le.PutUint16(file[0x1E:], 9)
+	le.PutUint32(file[0x30:], far)
+	le.PutUint32(file[0x44:], 0)
+	for i := range oleHeaderFATSlots {
+		le.PutUint32(file[0x4C+4*i:], 0xFFFFFFFF)
+	}
+
+	difat := sector(0)
+	for i := 0; i < 512; i += 4 {
+		le.PutUint32(difat[i:], 0xFFFFFFFF)
+	}
+	le.PutUint32(difat, 1)
+	le.PutUint32(difat[508:], 0xFFFFFFFE)
+	le.PutUint32(sector(1), far+1)
+	le.PutUint32(sector(1)[4:], 0xFFFFFFFE)
+
+	name := utf16.Encode([]rune(oleEncryptionStream))
+	entry := sector(far + 1)
+	for i, u := range name {
+		le.PutUint16(entry[2*i:], u)
+	}
+	le.PutUint16(entry[0x40:], uint16(2*len(name)+2))
+
+	found, err := oleHasStream(bytes.NewReader(file), oleEncryptionStream)
+	if err != nil || !found {
+		t.Errorf("oleHasStream = %v, %v, want true", found, err)
+	}
+}
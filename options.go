@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// parseNonNegativeInt reads the named query parameter as a non-negative
+// integer, returning def when the parameter is absent
+func parseNonNegativeInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", name, value)
+	}
+	return n, nil
+}
+
+// parseList splits a comma separated query value, dropping empty entries
+func parseList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseCleanOptions builds the cleaner.Options for a request from its query parameters
+func parseCleanOptions(r *http.Request) (cleaner.Options, error) {
+	opts := cleaner.DefaultOptions()
+	query := r.URL.Query()
+
+	var err error
+	if query.Get("skipTop") == "auto" {
+		opts.DetectStart = true
+	} else if opts.SkipTop, err = parseNonNegativeInt(r, "skipTop", cleaner.DefaultSkipTop); err != nil {
+		return opts, err
+	}
+	if query.Get("skipBottom") == "auto" {
+		opts.DetectFooter = true
+	} else if opts.SkipBottom, err = parseNonNegativeInt(r, "skipBottom", cleaner.DefaultSkipBottom); err != nil {
+		return opts, err
+	}
+	if opts.MinColumns, err = parseNonNegativeInt(r, "minColumns", 0); err != nil {
+		return opts, err
+	}
+	// maxSheets may only lower the server's limit
+	if opts.MaxSheets, err = parseNonNegativeInt(r, "maxSheets", cfg.MaxSheets); err != nil {
+		return opts, err
+	}
+	if cfg.MaxSheets > 0 && (opts.MaxSheets == 0 || opts.MaxSheets > cfg.MaxSheets) {
+		opts.MaxSheets = cfg.MaxSheets
+	}
+
+	// maxRows may only lower the server's limit too
+	if opts.MaxRows, err = parseNonNegativeInt(r, "maxRows", cfg.MaxRows); err != nil {
+		return opts, err
+	}
+	if cfg.MaxRows > 0 && (opts.MaxRows == 0 || opts.MaxRows > cfg.MaxRows) {
+		opts.MaxRows = cfg.MaxRows
+	}
+	switch action := query.Get("onMaxRows"); action {
+	case "", "truncate":
+	case "fail":
+		opts.FailOnMaxRows = true
+	default:
+		return opts, fmt.Errorf("invalid onMaxRows %q: must be truncate or fail", action)
+	}
+
+	if value := query.Get("numberFormat"); value != "" {
+		if opts.NumberFormat, err = cleaner.ParseNumberFormat(value); err != nil {
+			return opts, err
+		}
+	}
+	if query.Has("currencySymbols") {
+		opts.CurrencySymbols = parseList(query.Get("currencySymbols"))
+	}
+	if query.Has("creditIndicators") {
+		opts.CreditIndicators = parseList(query.Get("creditIndicators"))
+	}
+	if query.Has("debitIndicators") {
+		opts.DebitIndicators = parseList(query.Get("debitIndicators"))
+	}
+	if query.Has("amountSentinels") {
+		opts.AmountSentinels = parseList(query.Get("amountSentinels"))
+	}
+	opts.TrimWhitespace = query.Get("trim") != "false"
+	if value := query.Get("dateFormat"); value != "" {
+		opts.DateFormat = cleaner.ParseDateFormat(value)
+	}
+	opts.Columns = parseList(query.Get("columns"))
+	opts.Sheets = parseList(query.Get("sheets"))
+	opts.ExcludeSheets = parseList(query.Get("excludeSheets"))
+	opts.Strict = query.Get("strict") == "true"
+	opts.CalculateFormulas = query.Get("calculate") == "true"
+	opts.RawValues = query.Get("rawValues") == "true"
+	opts.Dedup = query.Get("dedup") == "true"
+
+	if opts.SortBy, err = cleaner.ParseSortField(query.Get("sort")); err != nil {
+		return opts, err
+	}
+	if value := query.Get("signConvention"); value != "" {
+		if opts.SignConvention, err = cleaner.ParseSignConvention(value); err != nil {
+			return opts, err
+		}
+	}
+	if value := query.Get("balance"); value != "" {
+		opening, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(opening, 0) || math.IsNaN(opening) {
+			return opts, fmt.Errorf("invalid balance %q: must be a number", value)
+		}
+		opts.OpeningBalance = &opening
+	}
+	for name, bound := range map[string]**float64{"minAmount": &opts.MinAmount, "maxAmount": &opts.MaxAmount} {
+		if value := query.Get(name); value != "" {
+			amount, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsInf(amount, 0) || math.IsNaN(amount) {
+				return opts, fmt.Errorf("invalid %s %q: must be a number", name, value)
+			}
+			*bound = &amount
+		}
+	}
+	if opts.MinAmount != nil && opts.MaxAmount != nil && *opts.MinAmount > *opts.MaxAmount {
+		return opts, fmt.Errorf("minAmount must not be greater than maxAmount")
+	}
+	// to is inclusive in the query but exclusive in the options, so that
+	// dates with a time of day on the last day are kept
+	for name, bound := range map[string]**time.Time{"from": &opts.From, "to": &opts.To} {
+		if value := query.Get(name); value != "" {
+			date, err := time.Parse(cleaner.DefaultDateFormat, value)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s %q: must be a YYYY-MM-DD date", name, value)
+			}
+			if name == "to" {
+				date = date.AddDate(0, 0, 1)
+			}
+			*bound = &date
+		}
+	}
+	if opts.From != nil && opts.To != nil && !opts.From.Before(*opts.To) {
+		return opts, fmt.Errorf("from must not be after to")
+	}
+	switch undated := query.Get("undated"); undated {
+	case "", "drop":
+	case "report":
+		opts.ReportUndated = true
+	default:
+		return opts, fmt.Errorf("invalid undated %q: must be drop or report", undated)
+	}
+	switch adds := query.Get("balanceAdds"); adds {
+	case "", "debit":
+	case "credit":
+		opts.CreditsIncreaseBalance = true
+	default:
+		return opts, fmt.Errorf("invalid balanceAdds %q: must be debit or credit", adds)
+	}
+
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.SortDesc = true
+	default:
+		return opts, fmt.Errorf("invalid order %q: must be asc or desc", order)
+	}
+	opts.DetectAmountColumn = query.Get("detectAmount") == "true"
+
+	// Header mapping is enabled explicitly or by naming any header
+	opts.MapHeaders = query.Get("mapHeaders") == "true"
+	for name, header := range map[string]*string{
+		"dateHeader":        &opts.DateHeader,
+		"descriptionHeader": &opts.DescriptionHeader,
+		"amountHeader":      &opts.AmountHeader,
+	} {
+		if value := query.Get(name); value != "" {
+			*header = value
+			opts.MapHeaders = true
+		}
+	}
+	opts.NoHeader = query.Get("hasHeader") == "false"
+	if opts.HeaderRows, err = parseNonNegativeInt(r, "skipHeaderRows", 1); err != nil {
+		return opts, err
+	}
+	if opts.NoHeader && opts.HeaderRows > 1 {
+		return opts, fmt.Errorf("hasHeader=false cannot be combined with skipHeaderRows")
+	}
+	if opts.NoHeader && (opts.MapHeaders || opts.DetectAmountColumn) {
+		return opts, fmt.Errorf("hasHeader=false cannot be combined with header mapping or detectAmount")
+	}
+	return opts, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCleanOptionsNumbers(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{query: "balance=100.50"},
+		{query: "balance=-20"},
+		{query: "balance=NaN", wantErr: true},
+		{query: "balance=Inf", wantErr: true},
+		{query: "balance=-Inf", wantErr: true},
+		{query: "balance=1e400", wantErr: true},
+		{query: "balance=abc", wantErr: true},
+		{query: "minAmount=5&maxAmount=10"},
+		{query: "minAmount=NaN", wantErr: true},
+		{query: "maxAmount=Inf", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := parseCleanOptions(httptest.NewRequest("POST", "/upload?"+tt.query, nil))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// outputMode selects the layout of the output archive
+type outputMode string
+
+const (
+	// outputSplit writes credits, debits and zero amounts to separate CSV files
+	outputSplit outputMode = "split"
+	// outputCombined writes one CSV with a type column
+	outputCombined outputMode = "combined"
+	// outputGzip returns the combined CSV gzip compressed instead of zipped
+	outputGzip outputMode = "gzip"
+	// outputOFX returns an OFX bank statement instead of an archive
+	outputOFX outputMode = "ofx"
+	// outputQIF returns a QIF bank account instead of an archive
+	outputQIF outputMode = "qif"
+	// outputXLSX returns a workbook with credit and debit sheets instead of
+	// an archive
+	outputXLSX outputMode = "xlsx"
+	// outputNDJSON returns one JSON object per transaction and line instead
+	// of an archive
+	outputNDJSON outputMode = "ndjson"
+	// outputByCategory writes the credit and debit totals of each category
+	// assigned by the category rules
+	outputByCategory outputMode = "summary-by-category"
+	// outputMonthly writes the credit, debit and net totals of each month
+	outputMonthly outputMode = "monthly"
+	// outputSigned writes one CSV of signed amounts with no type column
+	outputSigned outputMode = "signed"
+)
+
+// maxAmountFormatLen bounds the amountFormat number format code; Excel
+// rejects codes longer than 255 characters
+const maxAmountFormatLen = 255
+
+// utf8BOM is the UTF-8 encoded byte order mark
+const utf8BOM = "\uFEFF"
+
+// outputOptions controls how cleaned transactions are serialized
+type outputOptions struct {
+	Mode outputMode
+
+	// Delimiter separates fields in the CSV output. A tab writes .tsv files.
+	Delimiter rune
+
+	// Round is the number of decimal places amounts are rounded to, or -1
+	// to write them unrounded
+	Round int
+
+	// Precision is the number of decimal places amounts are written with,
+	// or -1 for as many as they need. It only affects formatting: amounts
+	// are rounded to Round places first, so round=2&outputPrecision=4
+	// writes 1.2345 as 1.2300. Without it, Round also sets the precision.
+	Precision int
+
+	// Layout, set by outputFiles when the upload was cleaned with a column
+	// selection, replaces the date, description and amount columns of
+	// delimited output
+	Layout *columnLayout
+
+	// SheetColumn and RowColumn append the source sheet name and 1-based
+	// row number to every CSV row, in that order
+	SheetColumn bool
+	RowColumn   bool
+
+	// BOM prepends a UTF-8 byte order mark to CSV and TSV files so Excel
+	// detects their encoding
+	BOM bool
+
+	// CreditName and DebitName are the names of the credit and debit CSV
+	// files in split mode
+	CreditName string
+	DebitName  string
+
+	// SplitBySheet writes separate credit, debit and zero files for each
+	// sheet in split mode, prefixed with the sheet name
+	SplitBySheet bool
+
+	// AmountFormat is the Excel number format code, such as
+	// "$#,##0.00;($#,##0.00)", applied to the amounts of xlsx output
+	AmountFormat string
+
+	// Account and Currency identify the statement in OFX output. BankID
+	// is the optional routing number.
+	Account  string
+	Currency string
+	BankID   string
+}
+
+// parseOutputOptions builds the outputOptions for a request from its query parameters
+func parseOutputOptions(r *http.Request) (outputOptions, error) {
+	opts := outputOptions{Mode: outputSplit, Delimiter: ',', Round: -1, Precision: -1}
+
+	switch mode := outputMode(r.URL.Query().Get("output")); mode {
+	case "":
+	case outputSplit, outputCombined, outputGzip, outputOFX, outputQIF, outputXLSX, outputNDJSON, outputByCategory, outputMonthly, outputSigned:
+		opts.Mode = mode
+	default:
+		return opts, fmt.Errorf("unknown output %q", mode)
+	}
+
+	if value := r.URL.Query().Get("delimiter"); value == "tab" {
+		opts.Delimiter = '\t'
+	} else if value != "" {
+		delimiter, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || !validDelimiter(delimiter) {
+			return opts, fmt.Errorf("invalid delimiter %q: must be a single character other than a quote or line break", value)
+		}
+		opts.Delimiter = delimiter
+	}
+
+	var err error
+	if opts.Round, err = parseNonNegativeInt(r, "round", -1); err != nil {
+		return opts, err
+	}
+	if opts.Precision, err = parseNonNegativeInt(r, "outputPrecision", -1); err != nil {
+		return opts, err
+	}
+
+	opts.BOM = r.URL.Query().Get("bom") == "true"
+	opts.SheetColumn = r.URL.Query().Get("sheetColumn") == "true"
+	opts.RowColumn = r.URL.Query().Get("rowIndex") == "true"
+	opts.SplitBySheet = r.URL.Query().Get("splitBySheet") == "true"
+	opts.AmountFormat = r.URL.Query().Get("amountFormat")
+	if len(opts.AmountFormat) > maxAmountFormatLen {
+		return opts, fmt.Errorf("amountFormat may be at most %d characters", maxAmountFormatLen)
+	}
+
+	ext := opts.ext()
+	opts.CreditName, opts.DebitName = "credits"+ext, "debits"+ext
+	for param, name := range map[string]*string{"creditName": &opts.CreditName, "debitName": &opts.DebitName} {
+		if value := r.URL.Query().Get(param); value != "" {
+			if !validCSVName(value, ext) {
+				return opts, fmt.Errorf("invalid %s %q: must be a plain file name ending in %s", param, value, ext)
+			}
+			*name = value
+		}
+	}
+	reserved := []string{"zero" + ext, "skipped" + ext}
+	if opts.CreditName == opts.DebitName || slices.Contains(reserved, opts.CreditName) || slices.Contains(reserved, opts.DebitName) {
+		return opts, fmt.Errorf("creditName and debitName must differ from each other and from %s", strings.Join(reserved, " and "))
+	}
+
+	if opts.Mode == outputOFX {
+		query := r.URL.Query()
+		opts.Account = strings.TrimSpace(query.Get("account"))
+		opts.Currency = strings.ToUpper(strings.TrimSpace(query.Get("currency")))
+		opts.BankID = strings.TrimSpace(query.Get("bankId"))
+		if opts.Account == "" {
+			return opts, fmt.Errorf("output ofx requires an account")
+		}
+		if !validCurrency(opts.Currency) {
+			return opts, fmt.Errorf("output ofx requires a three letter currency code, got %q", opts.Currency)
+		}
+		if opts.BankID == "" {
+			opts.BankID = "0"
+		}
+	}
+	return opts, nil
+}
+
+// ext returns the extension of the delimited files, .tsv when they are tab
+// separated and .csv otherwise
+func (opts outputOptions) ext() string {
+	if opts.Delimiter == '\t' {
+		return ".tsv"
+	}
+	return ".csv"
+}
+
+// validCSVName reports whether name is safe to use as a file name in the
+// output archive: letters, digits, spaces, dots, dashes and underscores,
+// not starting with a dot and ending in ext
+func validCSVName(name, ext string) bool {
+	if len(name) > 255 || len(name) <= len(ext) || name[0] == '.' || !strings.HasSuffix(strings.ToLower(name), ext) {
+		return false
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(" ._-", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validCurrency reports whether code looks like an ISO 4217 currency code
+func validCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// validDelimiter reports whether r can be used as csv.Writer.Comma
+func validDelimiter(r rune) bool {
+	return r != 0 && r != '"' && r != '\r' && r != '\n' && utf8.ValidRune(r) && r != utf8.RuneError
+}
+
+// formatAmount writes an amount for text output, rounded when opts.Round is
+// set and with opts.Precision decimal places when that is
+func formatAmount(amount float64, opts outputOptions) string {
+	precision := opts.Precision
+	if opts.Round >= 0 {
+		scale := math.Pow10(opts.Round)
+		amount = math.Round(amount*scale) / scale
+		if precision < 0 {
+			precision = opts.Round
+		}
+	}
+	return strconv.FormatFloat(amount, 'f', precision, 64)
+}
+
+// sourceHeader returns the header names of the optional columns locating
+// each transaction in the source file
+func sourceHeader(opts outputOptions) []string {
+	var header []string
+	if opts.SheetColumn {
+		header = append(header, "sheet")
+	}
+	if opts.RowColumn {
+		header = append(header, "row")
+	}
+	return header
+}
+
+// sourceColumns returns the optional columns locating t in the source file,
+// matching sourceHeader
+func sourceColumns(t cleaner.Transaction, opts outputOptions) []string {
+	var columns []string
+	if opts.SheetColumn {
+		columns = append(columns, t.Sheet)
+	}
+	if opts.RowColumn {
+		columns = append(columns, strconv.Itoa(t.Row))
+	}
+	return columns
+}
+
+// writeCSV serializes transactions into separate credit, debit and zero
+// amount CSV documents
+func writeCSV(transactions []cleaner.Transaction, opts outputOptions) (string, string, string, error) {
+	var creditCSV, debitCSV, zeroCSV strings.Builder
+	creditWriter := csv.NewWriter(&creditCSV)
+	creditWriter.Comma = opts.Delimiter
+	debitWriter := csv.NewWriter(&debitCSV)
+	debitWriter.Comma = opts.Delimiter
+	zeroWriter := csv.NewWriter(&zeroCSV)
+	zeroWriter.Comma = opts.Delimiter
+
+	for _, t := range transactions {
+		writer := debitWriter
+		switch t.Type {
+		case cleaner.Credit:
+			writer = creditWriter
+		case cleaner.Zero:
+			writer = zeroWriter
+		}
+		newRow := append(transactionCells(t, opts), sourceColumns(t, opts)...)
+		if err := writer.Write(newRow); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	for _, writer := range []*csv.Writer{creditWriter, debitWriter, zeroWriter} {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return "", "", "", err
+		}
+	}
+	return creditCSV.String(), debitCSV.String(), zeroCSV.String(), nil
+}
+
+// writeCombinedCSV serializes all transactions into one CSV document with a
+// header line and a type column. A balance column is added when the running
+// balance was computed.
+func writeCombinedCSV(transactions []cleaner.Transaction, opts outputOptions) (string, error) {
+	var combinedCSV strings.Builder
+	writer := csv.NewWriter(&combinedCSV)
+	writer.Comma = opts.Delimiter
+
+	withBalance := len(transactions) > 0 && transactions[0].Balance != nil
+	header := append(transactionHeader(opts), "type")
+	if withBalance {
+		header = append(header, "balance")
+	}
+	writer.Write(append(header, sourceHeader(opts)...))
+	for _, t := range transactions {
+		row := append(transactionCells(t, opts), string(t.Type))
+		if withBalance {
+			row = append(row, formatAmount(*t.Balance, opts))
+		}
+		writer.Write(append(row, sourceColumns(t, opts)...))
+	}
+	writer.Flush()
+	return combinedCSV.String(), writer.Error()
+}
+
+// writeSignedCSV serializes all transactions into one CSV document with a
+// header line, giving amounts the sign they had in the source: credits are
+// negative and debits positive, or the reverse under SignReversed. The
+// columns are laid out like the other delimited outputs.
+func writeSignedCSV(transactions []cleaner.Transaction, convention cleaner.SignConvention, opts outputOptions) (string, error) {
+	var signedCSV strings.Builder
+	writer := csv.NewWriter(&signedCSV)
+	writer.Comma = opts.Delimiter
+
+	writer.Write(append(transactionHeader(opts), sourceHeader(opts)...))
+	for _, t := range transactions {
+		if (t.Type == cleaner.Credit) != (convention == cleaner.SignReversed) && t.Type != cleaner.Zero {
+			t.Amount = -t.Amount
+		}
+		writer.Write(append(transactionCells(t, opts), sourceColumns(t, opts)...))
+	}
+	writer.Flush()
+	return signedCSV.String(), writer.Error()
+}
+
+// writeSkippedCSV serializes the skipped rows report with a header line
+func writeSkippedCSV(skipped []cleaner.SkippedRow, opts outputOptions) (string, error) {
+	var skippedCSV strings.Builder
+	writer := csv.NewWriter(&skippedCSV)
+	writer.Comma = opts.Delimiter
+
+	writer.Write([]string{"sheet", "row", "reason", "raw_amount"})
+	for _, s := range skipped {
+		writer.Write([]string{s.Sheet, strconv.Itoa(s.Row), string(s.Reason), s.RawAmount})
+	}
+	writer.Flush()
+	return skippedCSV.String(), writer.Error()
+}
+
+// outputFile is a named document placed in the output archive
+type outputFile struct {
+	Name string
+	Data []byte
+}
+
+// sheetTransactions are the transactions of one sheet, written to files
+// named with Prefix
+type sheetTransactions struct {
+	Prefix       string
+	Transactions []cleaner.Transaction
+}
+
+// groupBySheet splits transactions by sheet, in order of first appearance.
+// Each prefix is the sheet name, reduced to the characters allowed in
+// output file names, followed by an underscore. Sheets whose names reduce
+// to the same prefix are numbered.
+func groupBySheet(transactions []cleaner.Transaction) []sheetTransactions {
+	var groups []sheetTransactions
+	index := make(map[string]int)
+	used := make(map[string]bool)
+	for _, t := range transactions {
+		i, ok := index[t.Sheet]
+		if !ok {
+			base := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(" -_", r) {
+					return r
+				}
+				return '_'
+			}, t.Sheet)
+			prefix := base + "_"
+			for n := 2; used[prefix]; n++ {
+				prefix = fmt.Sprintf("%s_%d_", base, n)
+			}
+			used[prefix] = true
+			i = len(groups)
+			index[t.Sheet] = i
+			groups = append(groups, sheetTransactions{Prefix: prefix})
+		}
+		groups[i].Transactions = append(groups[i].Transactions, t)
+	}
+	return groups
+}
+
+// outputFiles serializes a cleaned upload into the files of the output
+// archive according to opts.Mode
+func outputFiles(upload *cleanedUpload, opts outputOptions) ([]outputFile, error) {
+	opts.Layout = newColumnLayout(upload)
+	var files []outputFile
+	switch opts.Mode {
+	case outputOFX:
+		ofx, err := writeOFX(upload, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"transactions.ofx", []byte(ofx)})
+	case outputQIF:
+		files = append(files, outputFile{"transactions.qif", []byte(writeQIF(upload.Transactions, opts))})
+	case outputNDJSON:
+		var ndjson bytes.Buffer
+		if err := writeNDJSON(&ndjson, upload.Transactions, opts); err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"transactions.ndjson", ndjson.Bytes()})
+	case outputXLSX:
+		workbook, err := writeXLSX(upload.Transactions, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"transactions.xlsx", workbook})
+	case outputByCategory:
+		categoryCSV, err := writeCategoryCSV(summarizeByCategory(upload.Transactions, categoryRules), opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"categories" + opts.ext(), []byte(categoryCSV)})
+	case outputMonthly:
+		months := summarizeByMonth(upload.Transactions, upload.Options.CreditsIncreaseBalance)
+		monthlyCSV, err := writeMonthlyCSV(months, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"monthly" + opts.ext(), []byte(monthlyCSV)})
+	case outputSigned:
+		signedCSV, err := writeSignedCSV(upload.Transactions, upload.Summary.SignConvention, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"transactions" + opts.ext(), []byte(signedCSV)})
+	case outputCombined, outputGzip:
+		combinedCSV, err := writeCombinedCSV(upload.Transactions, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, outputFile{"transactions" + opts.ext(), []byte(combinedCSV)})
+	default:
+		groups := []sheetTransactions{{"", upload.Transactions}}
+		if opts.SplitBySheet {
+			groups = groupBySheet(upload.Transactions)
+		}
+		for _, group := range groups {
+			creditCSV, debitCSV, zeroCSV, err := writeCSV(group.Transactions, opts)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files,
+				outputFile{group.Prefix + opts.CreditName, []byte(creditCSV)},
+				outputFile{group.Prefix + opts.DebitName, []byte(debitCSV)},
+				outputFile{group.Prefix + "zero" + opts.ext(), []byte(zeroCSV)},
+			)
+		}
+	}
+
+	skippedCSV, err := writeSkippedCSV(upload.Skipped, opts)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, outputFile{"skipped" + opts.ext(), []byte(skippedCSV)})
+
+	warnings, err := json.MarshalIndent(warningList(upload.Result), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, outputFile{"warnings.json", warnings})
+
+	metadata, err := json.MarshalIndent(newOutputMetadata(upload), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, outputFile{"metadata.json", metadata})
+
+	if opts.BOM {
+		for i, file := range files {
+			if strings.HasSuffix(file.Name, opts.ext()) {
+				files[i].Data = append([]byte(utf8BOM), file.Data...)
+			}
+		}
+	}
+	return files, nil
+}
+
+// warningList returns the warnings of result, empty rather than nil so they
+// encode as a JSON array
+func warningList(result *cleaner.Result) []cleaner.Warning {
+	if result.Warnings == nil {
+		return []cleaner.Warning{}
+	}
+	return result.Warnings
+}
+
+// outputMetadata records how an upload was processed, for the metadata.json
+// file of the output archive. The workbook password is never included.
+type outputMetadata struct {
+	Filename    string              `json:"filename"`
+	ProcessedAt time.Time           `json:"processedAt"`
+	Sheets      []cleaner.SheetInfo `json:"sheets"`
+	Options     cleaner.Options     `json:"options"`
+	Unresolved  []string            `json:"unresolvedHeaders,omitempty"`
+	Summary     cleaner.Summary     `json:"summary"`
+}
+
+func newOutputMetadata(upload *cleanedUpload) outputMetadata {
+	sheets := upload.Sheets
+	if sheets == nil {
+		sheets = []cleaner.SheetInfo{}
+	}
+	return outputMetadata{
+		Filename:    upload.Filename,
+		ProcessedAt: upload.ProcessedAt,
+		Sheets:      sheets,
+		Options:     upload.Options,
+		Unresolved:  upload.Unresolved,
+		Summary:     upload.Summary,
+	}
+}
+
+// jsonResponse is the body returned to clients that accept JSON
+type jsonResponse struct {
+	Credits  []cleaner.Transaction `json:"credits"`
+	Debits   []cleaner.Transaction `json:"debits"`
+	Zero     []cleaner.Transaction `json:"zero"`
+	Summary  cleaner.Summary       `json:"summary"`
+	Warnings []cleaner.Warning     `json:"warnings"`
+}
+
+// summaryHeaders are the headers setSummaryHeaders may set
+var summaryHeaders = []string{
+	"X-Credit-Count", "X-Credit-Total", "X-Debit-Count", "X-Debit-Total", "X-Zero-Count",
+	"X-Skipped-Count", "X-Warnings-Count", "X-Sign-Convention", "X-Closing-Balance", "X-Rows-Truncated",
+}
+
+// setSummaryHeaders exposes the summary counts and totals as response headers
+func setSummaryHeaders(w http.ResponseWriter, summary cleaner.Summary) {
+	w.Header().Set("X-Credit-Count", strconv.Itoa(summary.CreditCount))
+	w.Header().Set("X-Credit-Total", strconv.FormatFloat(summary.CreditTotal, 'f', -1, 64))
+	w.Header().Set("X-Debit-Count", strconv.Itoa(summary.DebitCount))
+	w.Header().Set("X-Debit-Total", strconv.FormatFloat(summary.DebitTotal, 'f', -1, 64))
+	w.Header().Set("X-Zero-Count", strconv.Itoa(summary.ZeroCount))
+	w.Header().Set("X-Skipped-Count", strconv.Itoa(summary.SkippedCount))
+	w.Header().Set("X-Warnings-Count", strconv.Itoa(summary.WarningCount))
+	w.Header().Set("X-Sign-Convention", string(summary.SignConvention))
+	if summary.ClosingBalance != nil {
+		w.Header().Set("X-Closing-Balance", strconv.FormatFloat(*summary.ClosingBalance, 'f', -1, 64))
+	}
+	if summary.RowsTruncated {
+		w.Header().Set("X-Rows-Truncated", "true")
+	}
+}
+
+// zipFiles packs files into an in-memory zip archive
+func zipFiles(files []outputFile) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+	for _, file := range files {
+		zipFile, err := zipWriter.Create(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zipFile.Write(file.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeZip writes archive as a zip download named filename
+func writeZip(w http.ResponseWriter, filename string, archive []byte) {
+	writeFile(w, filename, "application/zip", archive)
+}
+
+// writeFile writes data as a download of the given content type named filename
+func writeFile(w http.ResponseWriter, filename, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if _, err := w.Write(data); err != nil {
+		slog.Error("writing response", "filename", filename, "error", err)
+	}
+}
+
+// writeGzip writes data as a gzip compressed download named filename
+func writeGzip(w http.ResponseWriter, filename string, data []byte) error {
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// accepts reports whether the request's Accept header lists mediaType
+func accepts(r *http.Request, mediaType string) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		accepted, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && accepted == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMultipart streams files to the client as the parts of a
+// multipart/mixed response
+func writeMultipart(w http.ResponseWriter, files []outputFile) error {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for _, file := range files {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":        {contentTypeFor(file.Name)},
+			"Content-Disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": file.Name})},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// newJSONResponse splits the result into credits, debits and zero amounts.
+// When limit is not negative, at most limit transactions of each type are
+// included.
+func newJSONResponse(result *cleaner.Result, limit int) jsonResponse {
+	response := jsonResponse{
+		Credits:  []cleaner.Transaction{},
+		Debits:   []cleaner.Transaction{},
+		Zero:     []cleaner.Transaction{},
+		Summary:  result.Summary,
+		Warnings: warningList(result),
+	}
+	for _, t := range result.Transactions {
+		list := &response.Debits
+		switch t.Type {
+		case cleaner.Credit:
+			list = &response.Credits
+		case cleaner.Zero:
+			list = &response.Zero
+		}
+		if limit < 0 || len(*list) < limit {
+			*list = append(*list, t)
+		}
+	}
+	return response
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("encoding JSON", "request_id", w.Header().Get("X-Request-ID"), "error", err)
+		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBOM(t *testing.T) {
+	const bom = "\xef\xbb\xbf"
+	csv := "Date,Description,Amount\n2024-01-02,Café,-3.50\n2024-01-03,Salaire reçu,1000\n"
+	for _, query := range []string{"", "&bom=false", "&bom=true"} {
+		rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery+query, "a.csv", []byte(csv)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, want %d: %s", query, rec.Code, http.StatusOK, rec.Body)
+		}
+		files := unzipResponse(t, rec.Body.Bytes())
+		for _, name := range []string{"credits.csv", "debits.csv"} {
+			data, ok := files[name]
+			if !ok {
+				t.Fatalf("%q: no %s in %v", query, name, files)
+			}
+			if got, want := strings.HasPrefix(data, bom), query == "&bom=true"; got != want {
+				t.Errorf("%q: %s starts with a BOM: %v, want %v", query, name, got, want)
+			}
+			if strings.Count(data, bom) > 1 {
+				t.Errorf("%q: %s has more than one BOM", query, name)
+			}
+		}
+	}
+}
+
+func TestSignedOutputColumns(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{
+			query: "&output=signed",
+			want:  "date,description,amount\n2024-01-02,Coffee,-3.5\n2024-01-03,Salary,1000\n",
+		},
+		{
+			query: "&output=signed&columns=Amount,Description",
+			want:  "amount,description\n-3.5,Coffee\n1000,Salary\n",
+		},
+	}
+	for _, tt := range tests {
+		rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery+tt.query, "a.csv", []byte(statementCSV)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d, want %d: %s", tt.query, rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := unzipResponse(t, rec.Body.Bytes())["transactions.csv"]; got != tt.want {
+			t.Errorf("%q: transactions.csv = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
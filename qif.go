@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// qifDate is the US date layout QIF importers expect
+const qifDate = "01/02/2006"
+
+// writeQIF serializes the transactions as a QIF bank account. Credits are
+// written with positive amounts and debits with negative ones. Dates that
+// were not recognised are written as they appeared in the sheet.
+func writeQIF(transactions []cleaner.Transaction, opts outputOptions) string {
+	var b strings.Builder
+	b.WriteString("!Type:Bank\n")
+	for _, t := range transactions {
+		date := t.Date
+		if !t.ParsedDate.IsZero() {
+			date = t.ParsedDate.Format(qifDate)
+		}
+		amount := -t.Amount
+		if t.Type != cleaner.Debit {
+			amount = t.Amount
+		}
+		// Each field is a single line, so line breaks in the description
+		// would start a bogus field
+		payee := strings.Join(strings.Fields(t.Description), " ")
+		b.WriteString("D" + date + "\nT" + formatAmount(amount, opts) + "\nP" + payee + "\n^\n")
+	}
+	return b.String()
+}
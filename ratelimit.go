@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bucket is the token bucket of one client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter hands each client IP a token bucket refilled at cfg.RateLimit
+// tokens a second and holding at most cfg.RateBurst
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+var limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+
+// allow takes a token for the client, or reports how long until one is free
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(cfg.RateBurst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(float64(cfg.RateBurst), b.tokens+now.Sub(b.last).Seconds()*cfg.RateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / cfg.RateLimit * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets clients whose buckets have refilled every interval until
+// ctx is done
+func (l *rateLimiter) sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	refill := time.Duration(float64(cfg.RateBurst) / cfg.RateLimit * float64(time.Second))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for client, b := range l.buckets {
+				if now.Sub(b.last) > refill {
+					delete(l.buckets, client)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// trustedProxy reports whether addr is one of cfg.TrustedProxies
+func trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+	if err != nil {
+		return false
+	}
+	for _, prefix := range cfg.TrustedProxies {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client. When the connection comes
+// from a trusted proxy the X-Forwarded-For chain is followed back to the
+// first address that is not a trusted proxy.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// rateLimit answers 429 with Retry-After to clients that exceed their
+// token bucket. It passes everything through when cfg.RateLimit is zero.
+func rateLimit(next http.Handler) http.Handler {
+	if cfg.RateLimit == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := limiter.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(wait.Seconds())))))
+			writeError(w, r, "Too many requests, try again later", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	defer func(rate float64, burst int, proxies []netip.Prefix) {
+		cfg.RateLimit, cfg.RateBurst, cfg.TrustedProxies = rate, burst, proxies
+		limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+	}(cfg.RateLimit, cfg.RateBurst, cfg.TrustedProxies)
+	cfg.RateLimit, cfg.RateBurst = 0.5, 2
+	cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	limiter = &rateLimiter{buckets: make(map[string]*bucket)}
+	handler := rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func(remote, forwarded string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/upload", nil)
+		r.RemoteAddr = remote
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		want      int
+	}{
+		{name: "first of the burst", remote: "192.0.2.1:1000", want: http.StatusOK},
+		{name: "second of the burst", remote: "192.0.2.1:1001", want: http.StatusOK},
+		{name: "over the limit", remote: "192.0.2.1:1002", want: http.StatusTooManyRequests},
+		{name: "another client", remote: "192.0.2.2:1000", want: http.StatusOK},
+		{name: "limited client through a trusted proxy", remote: "10.0.0.1:1000", forwarded: "192.0.2.1", want: http.StatusTooManyRequests},
+		{name: "forwarded header from an untrusted peer", remote: "192.0.2.3:1000", forwarded: "192.0.2.2", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := request(tt.remote, tt.forwarded)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "2" {
+			t.Errorf("%s: Retry-After %q, want %q", tt.name, rec.Header().Get("Retry-After"), "2")
+		}
+	}
+}
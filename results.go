@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// resultPath is where the zip archive of a job is kept in cfg.ResultsDir
+func resultPath(id string) string {
+	return filepath.Join(cfg.ResultsDir, id+".zip")
+}
+
+// validJobID reports whether id has the form generated for jobs, so it is
+// safe to use in a file name
+func validJobID(id string) bool {
+	_, err := hex.DecodeString(id)
+	return err == nil && len(id) == 32
+}
+
+// saveResult writes the archive of a job to cfg.ResultsDir. It is written
+// under a temporary name first so downloads never see a partial file.
+func saveResult(id string, archive []byte) error {
+	path := resultPath(id)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, archive, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// sweepResults deletes archives older than cfg.ResultsTTL from
+// cfg.ResultsDir every interval until ctx is done
+func sweepResults(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			entries, err := os.ReadDir(cfg.ResultsDir)
+			if err != nil {
+				slog.Error("listing results", "dir", cfg.ResultsDir, "error", err)
+				continue
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".zip") {
+					continue
+				}
+				info, err := entry.Info()
+				if err != nil || now.Sub(info.ModTime()) < cfg.ResultsTTL {
+					continue
+				}
+				if err := os.Remove(filepath.Join(cfg.ResultsDir, entry.Name())); err != nil {
+					slog.Error("removing expired result", "name", entry.Name(), "error", err)
+				}
+			}
+		}
+	}
+}
+
+// jobDownloadHandler serves the archive of a job kept in cfg.ResultsDir,
+// which outlives the job itself until cfg.ResultsTTL passes
+func jobDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if cfg.ResultsDir == "" {
+		writeError(w, r, "Results are not retained on this server", http.StatusNotFound)
+		return
+	}
+	if !validJobID(id) {
+		writeError(w, r, "Result not found", http.StatusNotFound)
+		return
+	}
+
+	archive, err := os.ReadFile(resultPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, r, "Result not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("reading result", "job", id, "error", err)
+		writeError(w, r, "Unable to read result", http.StatusInternalServerError)
+		return
+	}
+
+	filename := id + ".zip"
+	if j, ok := jobs.get(id); ok {
+		filename = outputFilename(j.filename, ".zip")
+	}
+	writeZip(w, filename, archive)
+}
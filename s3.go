@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// s3Object is an output file stored in the S3 sink
+type s3Object struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	URL  string `json:"url"`
+}
+
+// s3Endpoint returns the base URL objects are addressed under, path style
+func s3Endpoint() string {
+	if cfg.S3Endpoint != "" {
+		return strings.TrimSuffix(cfg.S3Endpoint, "/")
+	}
+	return "https://s3." + cfg.S3Region + ".amazonaws.com"
+}
+
+// s3Escape percent-encodes an object key for the request path, leaving the
+// unreserved characters and slashes as SigV4 expects
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data keyed by key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// putS3Object uploads data under key in cfg.S3Bucket, signing the request
+// with AWS Signature Version 4 using the credentials in the environment
+func putS3Object(ctx context.Context, key, contentType string, data []byte) (string, error) {
+	objectPath := "/" + s3Escape(cfg.S3Bucket) + "/" + s3Escape(key)
+	objectURL := s3Endpoint() + objectPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256.Sum256(data)
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	req.Header.Set("X-Amz-Date", amzDate)
+	signed := []string{"content-type", "host", "x-amz-content-sha256", "x-amz-date"}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		objectPath,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + cfg.S3Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+os.Getenv("AWS_SECRET_ACCESS_KEY")), day)
+	signingKey = hmacSHA256(signingKey, cfg.S3Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		os.Getenv("AWS_ACCESS_KEY_ID"), scope, signedHeaders, signature))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("put %s: %s: %s", key, resp.Status, bytes.TrimSpace(body))
+	}
+	return objectURL, nil
+}
+
+// uploadToS3 stores files under a new folder of cfg.S3Prefix and returns
+// the objects created
+func uploadToS3(ctx context.Context, files []outputFile) ([]s3Object, error) {
+	folder := path.Join(cfg.S3Prefix, time.Now().UTC().Format("20060102"), randomID(8))
+	objects := make([]s3Object, 0, len(files))
+	for _, file := range files {
+		key := path.Join(folder, file.Name)
+		objectURL, err := putS3Object(ctx, key, contentTypeFor(file.Name), file.Data)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, s3Object{Name: file.Name, Key: key, URL: objectURL})
+	}
+	return objects, nil
+}
+
+// contentTypeFor returns the media type of an output file from its extension
+func contentTypeFor(name string) string {
+	switch path.Ext(name) {
+	case ".ofx":
+		return "application/x-ofx"
+	case ".qif":
+		return "application/qif"
+	case ".xlsx":
+		return xlsxContentType
+	case ".ndjson":
+		return ndjsonContentType
+	case ".tsv":
+		return "text/tab-separated-values; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// writeEvent sends one Server-Sent Event with v encoded as JSON
+func writeEvent(w http.ResponseWriter, event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	w.(http.Flusher).Flush()
+	return nil
+}
+
+// progressEvent is the data of a progress event
+type progressEvent struct {
+	cleaner.Progress
+	Message string `json:"message"`
+}
+
+// errorEvent is the data of an error event
+type errorEvent struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// streamUpload cleans the upload like /upload?stream=true, streaming
+// progress events while it runs. The stream ends with a result event holding
+// the JSON response, or an error event, and is then closed.
+func streamUpload(w http.ResponseWriter, r *http.Request) {
+	if _, ok := w.(http.Flusher); !ok {
+		writeError(w, r, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	pending := readUpload(w, r)
+	if pending == nil {
+		return
+	}
+	defer pending.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	progress := make(chan cleaner.Progress, 16)
+	pending.Options.Progress = progress
+
+	var upload *cleanedUpload
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		upload, err = pending.process(ctx)
+	}()
+
+loop:
+	for {
+		select {
+		case p := <-progress:
+			if writeEvent(w, "progress", progressEvent{p, p.String()}) != nil {
+				// The client went away; cancel and wait for cleaning to stop
+				cancel()
+				<-done
+				return
+			}
+		case <-done:
+			break loop
+		}
+	}
+
+	var uploadErr *uploadError
+	switch {
+	case errors.As(err, &uploadErr):
+		writeEvent(w, "error", errorEvent{uploadErr.Status, uploadErr.Message})
+	case err != nil:
+		// Canceled by the client disconnecting
+	default:
+		writeEvent(w, "result", newJSONResponse(upload.Result, -1))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempPrefix starts the name of every temporary file an upload is spooled to
+const tempPrefix = "uploaded-"
+
+// sweepTempFiles removes spooled uploads older than cfg.TempTTL from
+// cfg.TempDir. They are normally removed when processing ends, so any left
+// over were abandoned by a process that was killed mid-request.
+func sweepTempFiles() {
+	dir := cfg.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("listing temporary files", "dir", dir, "error", err)
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempPrefix) {
+			continue
+		}
+		if ext := filepath.Ext(entry.Name()); ext != ".xlsx" && ext != ".xlsm" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < cfg.TempTTL {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			slog.Error("removing stale temporary file", "name", entry.Name(), "error", err)
+			continue
+		}
+		slog.Info("removed stale temporary file", "name", entry.Name())
+	}
+}
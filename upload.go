@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// pendingUpload is a file read from an upload request, waiting to be cleaned
+type pendingUpload struct {
+	// Filename is the name the client gave the upload, possibly empty
+	Filename string
+	Format   inputFormat
+	File     io.ReadSeeker
+	Options  cleaner.Options
+	Logger   *slog.Logger
+
+	// AllowEmpty returns an upload without transactions instead of failing
+	// it, for callers that only report on the file
+	AllowEmpty bool
+
+	form multipart.File
+}
+
+// parseForm reads the multipart form of an upload request, limited to
+// cfg.MaxUploadBytes. On failure it writes an error response and returns
+// false. Parsing a request again is a no-op.
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		writeError(w, r, "Only POST method is allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if r.MultipartForm != nil {
+		return true
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxUploadBytes)
+	err := r.ParseMultipartForm(32 << 20)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, r, fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err != nil {
+		writeError(w, r, "Unable to read file from form", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// formFiles returns the files uploaded in the cfg.FormField field of a
+// parsed upload form. When that field is absent the files of the first
+// field, by name, holding any are used instead.
+func formFiles(r *http.Request) []*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	if files := r.MultipartForm.File[cfg.FormField]; len(files) > 0 {
+		return files
+	}
+	names := make([]string, 0, len(r.MultipartForm.File))
+	for name, files := range r.MultipartForm.File {
+		if len(files) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	slices.Sort(names)
+	return r.MultipartForm.File[names[0]]
+}
+
+// missingFileMessage explains that the form has no file, listing the
+// fields it does have
+func missingFileMessage(r *http.Request) string {
+	var names []string
+	if r.MultipartForm != nil {
+		for name := range r.MultipartForm.Value {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("No file uploaded; send it in the %q form field", cfg.FormField)
+	}
+	slices.Sort(names)
+	return fmt.Sprintf("No file uploaded; send it in the %q form field (found fields: %s)", cfg.FormField, strings.Join(names, ", "))
+}
+
+// readUpload reads the uploaded file, or the file at the URL in the url
+// form field, and the cleaning options given in the query string from the
+// request. On failure it writes an error response and
+// returns nil; otherwise the caller must Close the upload.
+func readUpload(w http.ResponseWriter, r *http.Request) (upload *pendingUpload) {
+	uploadsTotal.Inc()
+	defer func() {
+		if upload == nil {
+			uploadFailuresTotal.Inc()
+		}
+	}()
+
+	logger := requestLogger(w, r)
+
+	opts, err := parseCleanOptions(r)
+	if err != nil {
+		writeError(w, r, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	opts.Logger = logger
+
+	if !parseForm(w, r) {
+		return nil
+	}
+	files := formFiles(r)
+	rawURL := r.FormValue("url")
+	if len(files) == 0 && rawURL == "" {
+		writeError(w, r, missingFileMessage(r), http.StatusBadRequest)
+		return nil
+	}
+	opts.Password = r.FormValue("password")
+
+	if len(files) > 0 {
+		upload, err = openUpload(files[0], opts)
+	} else {
+		upload, err = fetchUpload(r.Context(), rawURL, opts)
+	}
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		writeError(w, r, uploadErr.Message, uploadErr.Status)
+		return nil
+	}
+	return upload
+}
+
+// openUpload opens an uploaded form file and detects its format. Failures
+// are returned as an *uploadError. The caller must Close the upload.
+func openUpload(header *multipart.FileHeader, opts cleaner.Options) (*pendingUpload, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "Unable to read file from form"}
+	}
+
+	format, err := detectFormat(file)
+	if err != nil {
+		file.Close()
+		return nil, &uploadError{http.StatusBadRequest, "Unable to read uploaded file"}
+	}
+	if format == formatUnknown {
+		file.Close()
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Uploaded file %q is not an xlsx or xlsm workbook or CSV file", header.Filename)}
+	}
+	if format == formatXLS {
+		file.Close()
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Uploaded file %q is a legacy xls workbook, which is not supported; save it as xlsx", header.Filename)}
+	}
+	if format == formatEncryptedXLSX && opts.Password == "" {
+		file.Close()
+		return nil, &uploadError{http.StatusBadRequest, "Workbook is password protected; provide it in the password field"}
+	}
+
+	return &pendingUpload{
+		Filename: header.Filename,
+		Format:   format,
+		File:     file,
+		Options:  opts,
+		Logger:   opts.Logger,
+		form:     file,
+	}, nil
+}
+
+// Close releases the uploaded form file
+func (p *pendingUpload) Close() error {
+	if p.form == nil {
+		return nil
+	}
+	err := p.form.Close()
+	p.form = nil
+	return err
+}
+
+// buffer reads the rest of the upload into memory, so it can still be
+// cleaned after the request has finished, and closes the form file
+func (p *pendingUpload) buffer() error {
+	data, err := io.ReadAll(p.File)
+	if err != nil {
+		return err
+	}
+	p.File = bytes.NewReader(data)
+	return p.Close()
+}
+
+// uploadError is a failure to clean an upload, carrying the status and
+// message reported to the client
+type uploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+// errBusy is returned when no processing slot became free in time
+var errBusy = &uploadError{http.StatusServiceUnavailable, "Server is busy, try again later"}
+
+// errorDetail returns the client message for an internal failure. Only with
+// cfg.Debug does it include err, which may reveal file paths and library
+// internals.
+func errorDetail(message string, err error) string {
+	if cfg.Debug {
+		return message + ": " + err.Error()
+	}
+	return message
+}
+
+// internalError logs err with the request's ID and answers with message
+func internalError(w http.ResponseWriter, r *http.Request, status int, message string, err error) {
+	slog.Error(message, "request_id", requestID(w, r), "error", err)
+	writeError(w, r, errorDetail(message, err), status)
+}
+
+// cleanedUpload is an uploaded file after cleaning
+type cleanedUpload struct {
+	// Filename is the name the client gave the upload, possibly empty
+	Filename string
+	*cleaner.Result
+
+	// Options are the cleaning options the upload was processed with
+	Options cleaner.Options
+
+	// ProcessedAt is when cleaning finished
+	ProcessedAt time.Time
+
+	// Unresolved lists the header names that were not found, in which case
+	// the default columns were used
+	Unresolved []string
+}
+
+// clean runs the cleaner over the upload. The caller holds a processing
+// slot. Failures are returned as an *uploadError, except that a canceled
+// ctx is returned as is since nobody is left to read a response.
+func (p *pendingUpload) clean(ctx context.Context) (upload *cleanedUpload, err error) {
+	defer func() {
+		if err != nil {
+			uploadFailuresTotal.Inc()
+		}
+	}()
+	// Cleaning may run outside a handler, where a panic would take the
+	// server down, so a malformed file only fails its own upload
+	defer func() {
+		if v := recover(); v != nil {
+			p.Logger.Error("processing panicked", "filename", p.Filename, "panic", v, "stack", string(debug.Stack()))
+			upload, err = nil, &uploadError{http.StatusInternalServerError, "Error processing file"}
+		}
+	}()
+
+	start := time.Now()
+	var result *cleaner.Result
+	switch {
+	case p.Format == formatCSV:
+		result, err = cleaner.CleanCSV(ctx, p.File, p.Options)
+	case !cfg.TempFiles:
+		result, err = cleaner.CleanReader(ctx, p.File, p.Options)
+	default:
+		// Keep the macro-enabled extension so the temp file matches its
+		// content type
+		ext := ".xlsx"
+		if strings.EqualFold(path.Ext(p.Filename), ".xlsm") {
+			ext = ".xlsm"
+		}
+		var tmpFile *os.File
+		tmpFile, err = os.CreateTemp(cfg.TempDir, tempPrefix+"*"+ext)
+		if err != nil {
+			return nil, &uploadError{http.StatusInternalServerError, "Unable to create temporary file"}
+		}
+		defer os.Remove(tmpFile.Name())
+
+		_, err = io.Copy(tmpFile, p.File)
+		tmpFile.Close()
+		if err != nil {
+			return nil, &uploadError{http.StatusInternalServerError, "Unable to save uploaded file"}
+		}
+
+		result, err = cleaner.Clean(ctx, tmpFile.Name(), p.Options)
+	}
+	processingDuration.Observe(time.Since(start).Seconds())
+
+	var unresolved []string
+	var unresolvedErr *cleaner.UnresolvedHeadersError
+	if errors.As(err, &unresolvedErr) {
+		// Fell back to the default columns; report which names were missing
+		unresolved = unresolvedErr.Names
+		err = nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, &uploadError{http.StatusServiceUnavailable, "Processing timed out"}
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	if errors.Is(err, cleaner.ErrTotalOverflow) {
+		return nil, &uploadError{http.StatusUnprocessableEntity, "Transaction totals are too large to represent"}
+	}
+	if errors.Is(err, cleaner.ErrWrongPassword) {
+		return nil, &uploadError{http.StatusBadRequest, "Incorrect workbook password"}
+	}
+	var missingErr *cleaner.MissingSheetsError
+	if errors.As(err, &missingErr) {
+		return nil, &uploadError{http.StatusBadRequest, missingErr.Error()}
+	}
+	var rowLimitErr *cleaner.RowLimitError
+	if errors.As(err, &rowLimitErr) {
+		return nil, &uploadError{http.StatusRequestEntityTooLarge, "Too many transactions: the limit is " + strconv.Itoa(rowLimitErr.Max)}
+	}
+	var amountErr *cleaner.AmountError
+	if errors.As(err, &amountErr) {
+		return nil, &uploadError{http.StatusUnprocessableEntity, amountErr.Error()}
+	}
+	var formatErr *cleaner.FormatError
+	if errors.As(err, &formatErr) {
+		p.Logger.Warn("unreadable upload", "filename", p.Filename, "error", err)
+		return nil, &uploadError{http.StatusUnprocessableEntity, errorDetail("Uploaded file could not be read", formatErr.Err)}
+	}
+	if err != nil {
+		p.Logger.Error("processing failed", "filename", p.Filename, "error", err)
+		return nil, &uploadError{http.StatusInternalServerError, errorDetail("Error processing file", err)}
+	}
+	rowsProcessedTotal.Add(float64(result.Summary.TransactionCount()))
+	rowsSkippedTotal.Add(float64(len(result.Skipped)))
+	p.Logger.Info("processed upload", "filename", p.Filename,
+		"credits", result.Summary.CreditCount, "debits", result.Summary.DebitCount, "skipped", len(result.Skipped))
+
+	if result.Summary.TransactionCount() == 0 && !p.AllowEmpty {
+		return nil, &uploadError{http.StatusUnprocessableEntity, noDataMessage(result)}
+	}
+	return &cleanedUpload{Filename: p.Filename, Result: result, Options: p.Options, ProcessedAt: time.Now().UTC(), Unresolved: unresolved}, nil
+}
+
+// noDataMessage explains why a file produced no transactions: it had no
+// sheets, no data rows, or every row it had was skipped or filtered out
+func noDataMessage(result *cleaner.Result) string {
+	summary := result.Summary
+	switch {
+	case summary.SheetCount == 0:
+		return "No data processed from the file: the workbook has no sheets to process"
+	case summary.RowCount == 0:
+		return "No data processed from the file: no data rows were left after trimming the header and footer rows"
+	}
+	return fmt.Sprintf("No data processed from the file: %d rows were read, %d skipped, %d duplicates removed and %d filtered out",
+		summary.RowCount, len(result.Skipped), summary.DuplicatesRemoved, summary.FilteredCount)
+}
+
+// process waits up to cfg.QueueTimeout for a processing slot, returning
+// errBusy if none is free, and cleans the upload within cfg.ProcessTimeout
+func (p *pendingUpload) process(ctx context.Context) (upload *cleanedUpload, err error) {
+	defer func() {
+		rows := 0
+		if upload != nil {
+			rows = upload.Summary.TransactionCount()
+		}
+		recordUpload(ctx, p.Filename, rows)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, cfg.ProcessTimeout)
+	defer cancel()
+
+	if !acquireSlot(ctx) {
+		uploadFailuresTotal.Inc()
+		return nil, errBusy
+	}
+	defer releaseSlot()
+	return p.clean(ctx)
+}
+
+// cleanUpload reads the uploaded file from the request and cleans it with
+// the options given in the query string. On failure it writes an error
+// response and returns nil.
+func cleanUpload(w http.ResponseWriter, r *http.Request) *cleanedUpload {
+	pending := readUpload(w, r)
+	if pending == nil {
+		return nil
+	}
+	return processUpload(w, r, pending)
+}
+
+// processUpload cleans and closes an upload read by readUpload. On failure
+// it writes an error response and returns nil.
+func processUpload(w http.ResponseWriter, r *http.Request, pending *pendingUpload) *cleanedUpload {
+	defer pending.Close()
+
+	// Abandon processing when the client goes away
+	upload, err := pending.process(r.Context())
+	if err != nil {
+		writeUploadError(w, r, err)
+		return nil
+	}
+	setUnresolvedHeader(w, upload)
+	return upload
+}
+
+// writeUploadError answers a request whose upload failed to process with
+// the error returned by pendingUpload.process. Nothing is written when the
+// client has gone away.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == errBusy {
+		setRetryAfter(w)
+	}
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) {
+		writeError(w, r, uploadErr.Message, uploadErr.Status)
+	}
+}
+
+// setUnresolvedHeader lists the header names of the upload that were not
+// found in the X-Unresolved-Headers header
+func setUnresolvedHeader(w http.ResponseWriter, upload *cleanedUpload) {
+	if len(upload.Unresolved) > 0 {
+		w.Header().Set("X-Unresolved-Headers", strings.Join(upload.Unresolved, ","))
+	}
+}
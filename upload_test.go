@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// unzipResponse returns the contents of the files in a zip response body by name
+func unzipResponse(t *testing.T, body []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, file := range zr.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file.Name] = string(data)
+	}
+	return files
+}
+
+func TestUploadTooLarge(t *testing.T) {
+	defer func(limit int64) { cfg.MaxUploadBytes = limit }(cfg.MaxUploadBytes)
+	cfg.MaxUploadBytes = 1 << 10
+
+	rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery, "big.csv", bytes.Repeat([]byte("x"), 2<<10)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("maximum size of 1024 bytes")) {
+		t.Errorf("body %q does not name the limit", rec.Body)
+	}
+
+	rec = serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery, "small.csv", []byte(statementCSV)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("upload under the limit: status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+func TestUploadMacroEnabledWorkbook(t *testing.T) {
+	content, err := os.ReadFile("testdata/statement.xlsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(uploadHandler, newUploadRequest(t, "/upload"+statementQuery, "statement.xlsm", content))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	files := unzipResponse(t, rec.Body.Bytes())
+	if got, want := files["credits.csv"], "2024-01-02,Coffee,3.5\n"; got != want {
+		t.Errorf("credits.csv = %q, want %q", got, want)
+	}
+	if got, want := files["debits.csv"], "2024-01-03,Salary,1000\n"; got != want {
+		t.Errorf("debits.csv = %q, want %q", got, want)
+	}
+}
+
+func TestUploadCompoundFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		message string
+	}{
+		{name: "legacy xls", content: compoundFile("Workbook"), message: "legacy xls workbook"},
+		{name: "encrypted xlsx without password", content: compoundFile("EncryptionInfo", "EncryptedPackage"), message: "password protected"},
+	}
+	for _, tt := range tests {
+		rec := serve(uploadHandler, newUploadRequest(t, "/upload", "statement.xls", tt.content))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, http.StatusBadRequest)
+		}
+		if !bytes.Contains(rec.Body.Bytes(), []byte(tt.message)) {
+			t.Errorf("%s: body %q does not mention %q", tt.name, rec.Body, tt.message)
+		}
+	}
+}
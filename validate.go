@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin/cleaner"
+)
+
+// validateResponse is the body of /validate
+type validateResponse struct {
+	Filename        string              `json:"filename"`
+	SheetCount      int                 `json:"sheetCount"`
+	SheetsTruncated int                 `json:"sheetsTruncated,omitempty"`
+	Sheets          []cleaner.SheetInfo `json:"sheets"`
+	Unresolved      []string            `json:"unresolvedHeaders"`
+	RowCount        int                 `json:"rowCount"`
+	UsableRows      int                 `json:"usableRows"`
+	SkippedCount    int                 `json:"skippedCount"`
+	Warnings        []cleaner.Warning   `json:"warnings"`
+}
+
+// validateHandler cleans the upload as a dry run and reports what was
+// detected, the header rows and columns of each sheet, how many rows would
+// become transactions and any warnings, without producing output. A file
+// without usable rows is reported rather than rejected.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	pending := readUpload(w, r)
+	if pending == nil {
+		return
+	}
+	pending.AllowEmpty = true
+	upload := processUpload(w, r, pending)
+	if upload == nil {
+		return
+	}
+	response := validateResponse{
+		Filename:        upload.Filename,
+		SheetCount:      upload.Summary.SheetCount,
+		SheetsTruncated: upload.Summary.SheetsTruncated,
+		Sheets:          upload.Sheets,
+		Unresolved:      upload.Unresolved,
+		RowCount:        upload.Summary.RowCount,
+		UsableRows:      len(upload.Transactions),
+		SkippedCount:    len(upload.Skipped),
+		Warnings:        warningList(upload.Result),
+	}
+	if response.Sheets == nil {
+		response.Sheets = []cleaner.SheetInfo{}
+	}
+	if response.Unresolved == nil {
+		response.Unresolved = []string{}
+	}
+	writeJSON(w, response)
+}
@@ -0,0 +1,21 @@
+package main
+
+import "net/http"
+
+// Build metadata, set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+	version   = "unknown"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// versionHandler reports which build is running
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"version":   version,
+		"commit":    commit,
+		"buildTime": buildTime,
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin/cleaner"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxContentType is the media type of xlsx workbooks
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// writeXLSX serializes transactions into a workbook with a Credits and a
+// Debits sheet, plus a Zero sheet when any amounts were zero. Each sheet has
+// a header row and amounts are written as numbers, displayed with
+// opts.AmountFormat when it is set.
+func writeXLSX(transactions []cleaner.Transaction, opts outputOptions) ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheets := map[cleaner.Type]string{cleaner.Credit: "Credits", cleaner.Debit: "Debits", cleaner.Zero: "Zero"}
+	header := []any{"Date", "Description", "Amount"}
+	for _, name := range sourceHeader(opts) {
+		header = append(header, strings.ToUpper(name[:1])+name[1:])
+	}
+	// rows counts the rows written to each sheet; a sheet is added on its
+	// first row, except that credits and debits always get one
+	rows := map[cleaner.Type]int{}
+	addSheet := func(typ cleaner.Type) error {
+		if _, err := f.NewSheet(sheets[typ]); err != nil {
+			return err
+		}
+		rows[typ] = 1
+		return f.SetSheetRow(sheets[typ], "A1", &header)
+	}
+	if err := f.SetSheetName(f.GetSheetName(0), sheets[cleaner.Credit]); err != nil {
+		return nil, err
+	}
+	if err := addSheet(cleaner.Credit); err != nil {
+		return nil, err
+	}
+	if err := addSheet(cleaner.Debit); err != nil {
+		return nil, err
+	}
+
+	for _, t := range transactions {
+		if rows[t.Type] == 0 {
+			if err := addSheet(t.Type); err != nil {
+				return nil, err
+			}
+		}
+		rows[t.Type]++
+
+		amount := t.Amount
+		if opts.Round >= 0 {
+			scale := math.Pow10(opts.Round)
+			amount = math.Round(amount*scale) / scale
+		}
+		cell, err := excelize.CoordinatesToCellName(1, rows[t.Type])
+		if err != nil {
+			return nil, err
+		}
+		row := []any{t.Date, t.Description, amount}
+		for _, value := range sourceColumns(t, opts) {
+			row = append(row, value)
+		}
+		if err := f.SetSheetRow(sheets[t.Type], cell, &row); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.AmountFormat != "" {
+		style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &opts.AmountFormat})
+		if err != nil {
+			return nil, err
+		}
+		for typ, last := range rows {
+			if last < 2 {
+				continue
+			}
+			end, err := excelize.CoordinatesToCellName(3, last)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.SetCellStyle(sheets[typ], "C2", end, style); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := f.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}